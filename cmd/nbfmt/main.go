@@ -0,0 +1,83 @@
+//Command nbfmt is the command line tool of nbfmt.
+//
+//Usage:
+//
+//	nbfmt gen -func Name -env Type [-pkg name] [-o output.go] template_file
+//
+//gen compiles the template file into a Go source file which contains a function
+//func Name(w io.Writer, env Type) error, the source is written to stdout if -o is not specified.
+//The generated file belongs to the Go package in the directory of the output file (the current directory
+//if -o is not specified), Type is resolved in the package.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wangjun861205/nbfmt"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: nbfmt gen -func Name -env Type [-pkg name] [-o output.go] template_file")
+	os.Exit(2)
+}
+
+func gen(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	pkg := fs.String("pkg", "", "package name of the generated file, it must be the package of the output directory")
+	funcName := fs.String("func", "Render", "name of the generated function")
+	envType := fs.String("env", "", "type of the env parameter of the generated function (required), usually a struct type of the package")
+	output := fs.String("o", "", "output file (default stdout)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	// the loops, comparisons and arithmetic of the generated code need the static types of the fields,
+	// so there is no default like map[string]interface{}
+	if *envType == "" {
+		return errors.New("nbfmt gen: -env is required, e.g. -env ReportData for a struct type of the package")
+	}
+	src, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	tmpl, err := nbfmt.Parse(string(src))
+	if err != nil {
+		return err
+	}
+	dir := "."
+	if *output != "" {
+		dir = filepath.Dir(*output)
+	}
+	code, err := nbfmt.GenerateGoPackage(tmpl, dir, *funcName, *envType)
+	if err != nil {
+		return err
+	}
+	if *pkg != "" && !bytes.Contains(code, []byte("\npackage "+*pkg+"\n")) {
+		return fmt.Errorf("nbfmt gen: the package of %s is not %s", dir, *pkg)
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(*output, code, 0644)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "gen":
+		if err := gen(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,487 @@
+package nbfmt
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type exprInner struct {
+	Value int64
+}
+
+type exprAddress struct {
+	City string
+}
+
+type exprCustomer struct {
+	Name    string
+	Address *exprAddress
+}
+
+type exprOrder struct {
+	Customer *exprCustomer
+	Tags     map[string]string
+}
+
+type exprStruct struct {
+	Name     string
+	Inner    exprInner
+	BoolList []bool
+}
+
+func evalExpr(src string, env map[string]interface{}) (interface{}, error) {
+	l := []*stmt{{src: "{{ " + src + " }}"}}
+	if err := parseIdents(l); err != nil {
+		return nil, err
+	}
+	e, err := parseExpression(l[0].idents)
+	if err != nil {
+		return nil, err
+	}
+	return e.eval(newScope(env, &options{}))
+}
+
+func TestExpression(t *testing.T) {
+	str := "hello"
+	env := map[string]interface{}{
+		"a":  true,
+		"b":  false,
+		"c":  false,
+		"x":  10,
+		"i":  int64(2),
+		"f":  1.5,
+		"l":  []int{1, 2, 3},
+		"m":  map[string]int{"k": 7},
+		"s":  exprStruct{Name: "foo", Inner: exprInner{Value: 21}, BoolList: []bool{false, true, true}},
+		"p":  &str,
+		"np": (*string)(nil),
+		"n":  nil,
+		"u":  "世界!",
+		"a3": [3]string{"a", "b", "c"},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"join": func(sep string, l ...string) string {
+			return strings.Join(l, sep)
+		},
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`1`, int64(1)},
+		{`-1`, int64(-1)},
+		{`1 + 2 * 3`, int64(7)},
+		{`(1 + 2) * 3`, int64(9)},
+		{`2 * (3 + 4)`, int64(14)},
+		{`10 - 3 - 2`, int64(5)},
+		{`10-3-2`, int64(5)},
+		{`100 / 10 / 5`, int64(2)},
+		{`2 * 3 - 4 / 2`, int64(4)},
+		{`10 - -3`, int64(13)},
+		{`-x + 1`, int64(-9)},
+		{`-(2 + 3)`, int64(-5)},
+		{`(x)-1`, int64(9)},
+		{`f * 2.0`, 3.0},
+		{`7.0 / 2.0`, 3.5},
+		{`"foo" + "bar"`, "foobar"},
+		{`'a'`, byte('a')},
+		{`nil`, nil},
+		{`!true`, false},
+		{`!a`, false},
+		{`!!a`, true},
+		{`!(a && b)`, true},
+		{`!(a || b) || c`, false},
+		{`a && !b`, true},
+		{`a || b && c`, true},
+		{`(a || b) && c`, false},
+		{`b && missing`, false},
+		{`a || missing`, true},
+		{`1 < 2 && 3 >= 3`, true},
+		{`1 + 1 == 2`, true},
+		{`x == 10`, true},
+		{`x != 10`, false},
+		{`x > 5 == true`, true},
+		{`x <= 9`, false},
+		{`l[1] + l[2]`, int64(5)},
+		{`l[i - 1]`, int64(2)},
+		{`l[0]-1`, int64(0)},
+		{`l[l[0]]`, int64(2)},
+		{`m["k"] * 2`, int64(14)},
+		{`s.Name`, "foo"},
+		{`s.Name + "bar"`, "foobar"},
+		{`s.Inner.Value * 2`, int64(42)},
+		{`!s.BoolList[2]`, false},
+		{`s.BoolList[1] && !s.BoolList[0]`, true},
+		{`*p`, "hello"},
+		{`*p == "hello"`, true},
+		{`add(x, 2) * 2`, int64(24)},
+		{`add(add(1, 2), l[2])`, int64(6)},
+		{`join("-", "a", s.Name)`, "a-foo"},
+		{`join(",")`, ""},
+		{`l[-1]`, int64(3)},
+		{`l[-3] + l[-1]`, int64(4)},
+		{`s.Name[0]`, "f"},
+		{`s.Name[-1]`, "o"},
+		{`(*p)[1:3]`, "el"},
+		{`s.Name[:2]`, "fo"},
+		{`s.Name[1:]`, "oo"},
+		{`s.Name[:]`, "foo"},
+		{`s.Name[-2:]`, "oo"},
+		{`s.Name[:-1]`, "fo"},
+		{`s.Name[1:100]`, "oo"},
+		{`s.Name[-100:1]`, "f"},
+		{`s.Name[2:1]`, ""},
+		{`u[1:3]`, "界!"},
+		{`u[1]`, "界"},
+		{`l[1:][0]`, int64(2)},
+		{`l[i-1:i][0]`, int64(2)},
+		{`l[:x]`, "[1 2 3]"},
+		{`l[5:]`, "[]"},
+		{`a3[1:]`, "[b c]"},
+		{`a3[-1:][0]`, "c"},
+		{`[1, "a", x][2]`, int64(10)},
+		{`[1, 2, 3,][-1]`, int64(3)},
+		{`[[1, 2], [3]][0][1]`, int64(2)},
+		{`[]`, "[]"},
+		{`{"k": x * 2, "n": s.Name}["k"]`, int64(20)},
+		{`{"a": {"b": [1, 2]}}["a"]["b"][1]`, int64(2)},
+		{`"k" in {"k": nil}`, true},
+		{`2 in l`, true},
+		{`4 in l`, false},
+		{`i in [1, 2]`, true},
+		{`"k" in m`, true},
+		{`"x" in m`, false},
+		{`"oo" in s.Name`, true},
+		{`s.Name in ["foo", "bar"]`, true},
+		{`!("baz" in ["foo", "bar"])`, true},
+		{`1 + 1 in [2] && true`, true},
+		{`[1] in [[1], [2]]`, true},
+		{`1 in nil`, false},
+	}
+	for _, test := range tests {
+		got, err := evalExpr(test.src, env)
+		if v := reflect.ValueOf(got); v.Kind() == reflect.Slice {
+			got = fmt.Sprint(got)
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v (%T), want %v (%T)", test.src, got, got, test.want, test.want)
+		}
+	}
+	for _, src := range []string{
+		`*n`,
+		`*np`,
+		`*x`,
+	} {
+		if got, err := evalExpr(src, env); err == nil {
+			t.Errorf("%s: expected error, got %v", src, got)
+		}
+	}
+}
+
+type exprBase struct {
+	ID   int
+	Name string
+}
+
+type exprMeta struct {
+	Version int
+}
+
+type exprEmbed struct {
+	exprBase
+	*exprMeta
+	Name   string
+	secret string
+}
+
+type exprKey string
+
+func TestFieldAndKey(t *testing.T) {
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"database": {"host": "localhost", "port": 5432, "replicas": ["a", "b"]}}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]interface{}{
+		"config":  config,
+		"pconfig": &config,
+		"e":       exprEmbed{exprBase: exprBase{ID: 7, Name: "base"}, exprMeta: &exprMeta{Version: 2}, Name: "outer"},
+		"nilMeta": exprEmbed{},
+		"ints":    map[int]string{1: "one", 2: "two"},
+		"bytes":   map[uint8]string{200: "b"},
+		"keys":    map[exprKey]int{"k": 1},
+		"iface":   map[interface{}]string{"k": "v", int64(1): "one"},
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`config.database.host`, "localhost"},
+		{`config.database.port`, 5432.0},
+		{`config.database.replicas[1]`, "b"},
+		{`pconfig.database["host"]`, "localhost"},
+		{`config?.database?.missing`, nil},
+		{`e.ID + 1`, int64(8)},
+		{`e.Name`, "outer"},
+		{`e.Version`, int64(2)},
+		{`ints[1]`, "one"},
+		{`ints[1 + 1]`, "two"},
+		{`bytes[200]`, "b"},
+		{`keys["k"]`, int64(1)},
+		{`keys.k`, int64(1)},
+		{`iface.k`, "v"},
+		{`iface[1]`, "one"},
+		{`2 in ints`, true},
+		{`300 in bytes`, false},
+	}
+	for _, test := range tests {
+		got, err := evalExpr(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v (%T), want %v (%T)", test.src, got, got, test.want, test.want)
+		}
+	}
+	for _, src := range []string{
+		`config.missing`,
+		`e.secret`,
+		`e.exprBase`,
+		`e.Missing`,
+		`nilMeta.Version`,
+		`ints.one`,
+		`ints["1"]`,
+		`ints[1.5]`,
+		`bytes[300]`,
+		`bytes[-1]`,
+	} {
+		if got, err := evalExpr(src, env); err == nil {
+			t.Errorf("%s: expected error, got %v", src, got)
+		}
+	}
+}
+
+func TestOptionalChaining(t *testing.T) {
+	env := map[string]interface{}{
+		"full":   &exprOrder{Customer: &exprCustomer{Name: "bob", Address: &exprAddress{City: "paris"}}, Tags: map[string]string{"k": "v"}},
+		"noAddr": &exprOrder{Customer: &exprCustomer{Name: "bob"}},
+		"noCust": &exprOrder{},
+		"nilOrd": (*exprOrder)(nil),
+		"none":   nil,
+		"l":      []int{1, 2},
+		"orders": []*exprOrder{nil},
+		"m":      map[string]interface{}{"a": map[string]interface{}{"b": "c"}},
+		"x":      5,
+		"e":      exprEmbed{exprBase: exprBase{ID: 7}, Name: "n", secret: "s"},
+	}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`full?.Customer?.Address?.City`, "paris"},
+		{`full.Customer?.Address.City`, "paris"},
+		{`noAddr.Customer.Address?.City`, nil},
+		{`noCust.Customer?.Address.City`, nil},
+		{`nilOrd?.Customer.Address.City`, nil},
+		{`none?.Customer`, nil},
+		{`noAddr.Customer?.Address?.City == nil`, true},
+		{`full?.Tags?["k"]`, "v"},
+		{`noCust.Tags?["k"]`, nil},
+		{`full.Tags?["missing"]`, nil},
+		{`l?[1]`, int64(2)},
+		{`orders[0]?.Customer.Name`, nil},
+		{`m?["a"]?["b"]`, "c"},
+		{`m?["x"]?["b"]`, nil},
+		{`m?["x"]["b"]`, nil},
+		{`noCust.Customer?.Address.City[1:]`, nil},
+		{`full.Customer?.Name[1:]`, "ob"},
+		{`m?.x?.b`, nil},
+		{`e?.ID`, int64(7)},
+	}
+	for _, test := range tests {
+		got, err := evalExpr(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v (%T), want %v (%T)", test.src, got, got, test.want, test.want)
+		}
+	}
+	for _, src := range []string{
+		`noCust.Customer.Address?.City`,
+		`full.Tags["missing"]`,
+		`m?["x"]["b"] + 1`,
+		`l?`,
+		`l?.`,
+		`?.l`,
+		`full?.Missing`,
+		`x?.Foo`,
+		`e?.secret`,
+		`e?.Nope`,
+		`l?[5]`,
+		`l?["a"]`,
+		`m?[1]`,
+		`x?[0]`,
+	} {
+		if got, err := evalExpr(src, env); err == nil {
+			t.Errorf("%s: expected error, got %v", src, got)
+		}
+	}
+}
+
+func TestComparison(t *testing.T) {
+	t1 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var nilAddr *exprAddress
+	env := map[string]interface{}{
+		"name":  "Mike",
+		"n":     3,
+		"u8":    uint8(3),
+		"f32":   float32(1.5),
+		"b":     []byte("abc"),
+		"t1":    t1,
+		"t2":    t1.Add(time.Hour),
+		"t1Loc": t1.In(time.FixedZone("CST", 8*3600)),
+		"d":     90 * time.Minute,
+		"hour":  time.Hour,
+		"ints":  []int{1, 2},
+		"ints2": []int{1, 2},
+		"strs":  []string{"a"},
+		"m1":    map[string]int{"a": 1},
+		"m2":    map[string]interface{}{"a": int64(1)},
+		"addr1": exprAddress{City: "Paris"},
+		"addr2": exprAddress{City: "Paris"},
+		"addr":  nilAddr,
+		"v1":    version{1, 2},
+		"v2":    version{1, 10},
+	}
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{`name < "N"`, true},
+		{`name >= "Mike"`, true},
+		{`"a" < "B"`, false},
+		{`n == 3`, true},
+		{`n == u8`, true},
+		{`u8 > -1`, true},
+		{`n == 3.0`, true},
+		{`f32 == 1.5`, true},
+		{`n < 3.5`, true},
+		{`b == "abc"`, true},
+		{`b < "abd"`, true},
+		{`t1 < t2`, true},
+		{`t2 <= t1`, false},
+		{`t1 == t1Loc`, true},
+		{`d > hour`, true},
+		{`ints == ints2`, true},
+		{`ints == [1, 2]`, true},
+		{`ints != [1, 2, 3]`, true},
+		{`ints == strs`, false},
+		{`m1 == m2`, true},
+		{`m1 == {"a": 2}`, false},
+		{`addr1 == addr2`, true},
+		{`addr == nil`, true},
+		{`nil == addr1`, false},
+		{`'a' < 'b'`, true},
+		{`v1 < v2`, true},
+		{`v2 == v1`, false},
+		{`[1, "a"] == [1, "a"]`, true},
+	}
+	for _, test := range tests {
+		got, err := evalExpr(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`name < 1`,
+		`t1 > "2024"`,
+		`ints < ints2`,
+		`nil < 1`,
+	} {
+		if got, err := evalExpr(src, env); err == nil {
+			t.Errorf("%s: expected error, got %v", src, got)
+		}
+	}
+}
+
+func TestExpressionError(t *testing.T) {
+	env := map[string]interface{}{
+		"x": 10,
+		"l": []int{1, 2, 3},
+		"add": func(a, b int) int {
+			return a + b
+		},
+	}
+	tests := []string{
+		`1 +`,
+		`(1 + 2`,
+		`l[0`,
+		`1 + 2)`,
+		`x.`,
+		`missing`,
+		`!1`,
+		`-"foo"`,
+		`true + 1`,
+		`x / 0`,
+		`l["a"]`,
+		`x.Field`,
+		`x(1)`,
+		`add(1)`,
+		`add(1, "a")`,
+		`add(1, 2`,
+		`l[3]`,
+		`l[-4]`,
+		`l[]`,
+		`l[1:2:3]`,
+		`l["a":]`,
+		`x[1:]`,
+		`l[1:`,
+		`[1, 2`,
+		`[1 2]`,
+		`{"a" 1}`,
+		`{1: 2}`,
+		`{"a": 1`,
+		`1 in x`,
+		`1 in "abc"`,
+	}
+	for _, src := range tests {
+		if got, err := evalExpr(src, env); err == nil {
+			t.Errorf("%s: expected error, got %v", src, got)
+		}
+	}
+}
+
+func TestExpressionReuse(t *testing.T) {
+	l := []*stmt{{src: "{{ x * 2 + 1 }}"}}
+	if err := parseIdents(l); err != nil {
+		t.Fatal(err)
+	}
+	e, err := parseExpression(l[0].idents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3; i++ {
+		got, err := e.eval(newScope(map[string]interface{}{"x": i}, &options{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != i*2+1 {
+			t.Errorf("eval %d: got %v, want %d", i, got, i*2+1)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package nbfmt
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"testing"
 )
 
@@ -27,3 +29,707 @@ func TestFmt(t *testing.T) {
 	}
 	fmt.Println(s)
 }
+
+func TestLoopVar(t *testing.T) {
+	env := map[string]interface{}{
+		"rows": [][]string{{"a", "b"}, {"c"}},
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ for i, r in rows }}{{ loop.index }}{{ loop.index1 }}{{ loop.revindex }}{{ loop.length }};{{ endfor }}`, "0112;1202;"},
+		{`{{ for i, r in rows }}{{ if loop.first }}[{{ endif }}{{ i }}{{ if loop.last }}]{{ else }},{{ endif }}{{ endfor }}`, "[0,1]"},
+		{`{{ for i, r in rows }}{{ for j, c in r }}{{ loop.parent.index }}{{ c }} {{ endfor }}{{ endfor }}`, "0a 0b 1c "},
+		{`{{ for i, r in rows }}{{ loop.parent == nil }}{{ endfor }}`, "truetrue"},
+		{`{{ for i, r in rows }}{{ for j, c in r }}{{ loop.cycle("odd", "even") }} {{ endfor }}{{ endfor }}`, "odd even odd "},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestForElse(t *testing.T) {
+	src := `{{ for i, v in list }}{{ v }},{{ else }}No results{{ endfor }}`
+	tests := []struct {
+		list interface{}
+		want string
+	}{
+		{[]string{"a", "b"}, "a,b,"},
+		{[]string{}, "No results"},
+		{[]string(nil), "No results"},
+		{map[string]int{}, "No results"},
+		{map[string]int{"a": 1}, "1,"},
+		{nil, "No results"},
+	}
+	for _, test := range tests {
+		got, err := Fmt(src, map[string]interface{}{"list": test.list})
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.list, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%v: got %q, want %q", test.list, got, test.want)
+		}
+	}
+	got, err := Fmt(`{{ for i, v in list }}{{ if v }}y{{ else }}n{{ endif }}{{ else }}empty{{ endfor }}`, map[string]interface{}{"list": []bool{true, false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "yn" {
+		t.Errorf("nested else: got %q, want %q", got, "yn")
+	}
+	if _, err := Fmt(`{{ for i, v in list }}a{{ else }}b{{ endif }}`, nil); err == nil {
+		t.Error("expected error for else block ended by endif")
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	env := map[string]interface{}{
+		"list": []int{1, 2, 3, 4, 5},
+		"rows": [][]int{{1, 2}, {3, 4}},
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ for i, v in list }}{{ v }}{{ if v == 3 }}{{ break }}{{ endif }},{{ endfor }}`, "1,2,3"},
+		{`{{ for i, v in list }}{{ if v == 2 || v == 4 }}{{ continue }}{{ endif }}{{ v }}{{ endfor }}`, "135"},
+		{`{{ for i, v in list }}{{ switch v }}{{ case 2 }}{{ continue }}{{ case 4 }}{{ break }}{{ endswitch }}{{ v }}{{ endfor }}`, "13"},
+		{`{{ for i, r in rows }}{{ for j, v in r }}{{ if j == 1 }}{{ break }}{{ endif }}{{ v }}{{ endfor }};{{ endfor }}`, "1;3;"},
+		{`{{ for i, v in list }}{{ if v > 1 }}{{ break }}{{ else }}{{ v }}{{ endif }}{{ endfor }}`, "1"},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`{{ break }}`,
+		`{{ if true }}{{ continue }}{{ endif }}`,
+		`{{ for i, v in list }}a{{ else }}{{ break }}{{ endfor }}`,
+		`{{ for i, v in list }}{{ break 1 }}{{ endfor }}`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%s: expected parse error", src)
+		}
+	}
+}
+
+func TestForForms(t *testing.T) {
+	env := map[string]interface{}{
+		"list": []string{"a", "b", "c"},
+		"n":    3,
+		"zero": 0,
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ for v in list }}{{ v }}{{ endfor }}`, "abc"},
+		{`{{ for v in list }}{{ loop.index }}{{ v }}{{ endfor }}`, "0a1b2c"},
+		{`{{ for i in range(3) }}{{ i }}{{ endfor }}`, "012"},
+		{`{{ for i in range(1, 4) }}{{ i }}{{ endfor }}`, "123"},
+		{`{{ for i in range(1, 10, 3) }}{{ i }},{{ endfor }}`, "1,4,7,"},
+		{`{{ for i in range(5, 0, -2) }}{{ i }},{{ endfor }}`, "5,3,1,"},
+		{`{{ for i in range(3, 1) }}{{ i }}{{ else }}empty{{ endfor }}`, "empty"},
+		{`{{ for i in 1..5 }}{{ i }}{{ endfor }}`, "12345"},
+		{`{{ for i in 3..1 }}{{ i }}{{ endfor }}`, "321"},
+		{`{{ for i, v in 1 .. n + 1 }}{{ i }}:{{ v }} {{ endfor }}`, "0:1 1:2 2:3 3:4 "},
+		{`{{ for i in n }}{{ i }}{{ endfor }}`, "012"},
+		{`{{ for i in zero }}{{ i }}{{ else }}none{{ endfor }}`, "none"},
+		{`{{ for i in range(2) }}{{ for j in range(i + 1) }}{{ i }}{{ j }} {{ endfor }}{{ endfor }}`, "00 10 11 "},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`{{ for in list }}a{{ endfor }}`,
+		`{{ for v list }}a{{ endfor }}`,
+		`{{ for i, in list }}a{{ endfor }}`,
+		`{{ for i in range(1, 2, 0) }}a{{ endfor }}`,
+		`{{ for i in 1.5 }}a{{ endfor }}`,
+	} {
+		if _, err := Fmt(src, env); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+type countdown int
+
+func (c countdown) Iterate(yield func(key, value interface{}) bool) error {
+	for i := int(c); i > 0; i-- {
+		if !yield(int(c)-i, i) {
+			return nil
+		}
+	}
+	return nil
+}
+
+type failIter struct{}
+
+func (failIter) Iterate(yield func(key, value interface{}) bool) error {
+	yield(0, "a")
+	return errors.New("read failed")
+}
+
+func TestForStream(t *testing.T) {
+	newChan := func() chan int {
+		ch := make(chan int, 3)
+		ch <- 1
+		ch <- 2
+		ch <- 3
+		close(ch)
+		return ch
+	}
+	closed := make(chan int)
+	close(closed)
+	seq := func(yield func(string) bool) {
+		for _, s := range []string{"x", "y", "z"} {
+			if !yield(s) {
+				return
+			}
+		}
+	}
+	seq2 := func(yield func(string, int) bool) {
+		if yield("a", 1) {
+			yield("b", 2)
+		}
+	}
+	tests := []struct {
+		src  string
+		env  map[string]interface{}
+		want string
+	}{
+		{`{{ for i, c in s }}{{ i }}{{ c }} {{ endfor }}`, map[string]interface{}{"s": "héllo"}, "0h 1é 2l 3l 4o "},
+		{`{{ for c in s }}{{ c }}{{ else }}empty{{ endfor }}`, map[string]interface{}{"s": ""}, "empty"},
+		{`{{ for i, v in ch }}{{ i }}:{{ v }}{{ if !loop.last }},{{ endif }}{{ endfor }}`, map[string]interface{}{"ch": newChan()}, "0:1,1:2,2:3"},
+		{`{{ for v in ch }}{{ if v == 2 }}{{ break }}{{ endif }}{{ v }}{{ endfor }}`, map[string]interface{}{"ch": newChan()}, "1"},
+		{`{{ for v in ch }}{{ v }}{{ else }}empty{{ endfor }}`, map[string]interface{}{"ch": closed}, "empty"},
+		{`{{ for i, v in seq }}{{ i }}{{ v }}{{ if loop.last }}.{{ endif }}{{ endfor }}`, map[string]interface{}{"seq": seq}, "0x1y2z."},
+		{`{{ for v in seq }}{{ v }}{{ if loop.first }}{{ break }}{{ endif }}{{ endfor }}`, map[string]interface{}{"seq": seq}, "x"},
+		{`{{ for k, v in seq2 }}{{ k }}={{ v }} {{ endfor }}`, map[string]interface{}{"seq2": seq2}, "a=1 b=2 "},
+		{`{{ for i, v in c }}{{ i }}{{ v }}{{ if loop.last }}!{{ endif }} {{ endfor }}`, map[string]interface{}{"c": countdown(3)}, "03 12 21! "},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, test.env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, test := range []struct {
+		src string
+		env map[string]interface{}
+	}{
+		{`{{ for v in c }}{{ loop.length }}{{ endfor }}`, map[string]interface{}{"c": countdown(2)}},
+		{`{{ for v in c }}{{ v }}{{ endfor }}`, map[string]interface{}{"c": failIter{}}},
+		{`{{ for v in ch }}{{ v }}{{ endfor }}`, map[string]interface{}{"ch": make(chan<- int)}},
+		{`{{ for v in f }}{{ v }}{{ endfor }}`, map[string]interface{}{"f": func() int { return 1 }}},
+	} {
+		if _, err := Fmt(test.src, test.env); err == nil {
+			t.Errorf("%s: expected error", test.src)
+		}
+	}
+}
+
+type filterItem struct {
+	Name   string
+	Active bool
+}
+
+func TestForFilter(t *testing.T) {
+	items := []filterItem{{"a", false}, {"b", true}, {"c", false}, {"d", true}, {"e", false}}
+	newChan := func() chan int {
+		ch := make(chan int, 5)
+		for i := 1; i <= 5; i++ {
+			ch <- i
+		}
+		close(ch)
+		return ch
+	}
+	env := map[string]interface{}{
+		"items": items,
+		"m":     map[string]int{"a": 1, "b": 2, "c": 3},
+		"ch":    newChan(),
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ for i, v in items if v.Active }}{{ i }}{{ v.Name }}{{ if !loop.last }},{{ endif }}{{ endfor }}`, "0b,1d"},
+		{`{{ for v in items if v.Active }}{{ loop.index1 }}/{{ loop.length }}{{ if loop.first }}first{{ endif }} {{ endfor }}`, "1/2first 2/2 "},
+		{`{{ for v in items if !v.Active && v.Name != "c" }}{{ v.Name }}{{ endfor }}`, "ae"},
+		{`{{ for i, v in items if i > 2 }}{{ v.Name }}{{ endfor }}`, "de"},
+		{`{{ for v in items if v.Name == "x" }}{{ v.Name }}{{ else }}none{{ endfor }}`, "none"},
+		{`{{ for k, v in m if v != 2 }}{{ k }}{{ v }}{{ endfor }}`, "a1c3"},
+		{`{{ for k, v in m desc if v > 1 }}{{ k }}{{ endfor }}`, "cb"},
+		{`{{ for i in range(10) if i > 6 }}{{ i }}{{ endfor }}`, "789"},
+		{`{{ for i, v in ch if v != 2 && v != 4 }}{{ i }}:{{ v }}{{ if loop.last }}.{{ else }},{{ endif }}{{ endfor }}`, "0:1,1:3,2:5."},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`{{ for v in items if }}a{{ endfor }}`,
+		`{{ for v in items if v.Name }}a{{ endfor }}`,
+		`{{ for v in items if v.Missing }}a{{ endfor }}`,
+	} {
+		if _, err := Fmt(src, env); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	env := map[string]interface{}{"status": "open", "n": 2}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ if status in ["open", "pending"] }}active{{ endif }}`, "active"},
+		{`{{ for v in [1, n, "x"] }}{{ v }}{{ endfor }}`, "12x"},
+		{`{{ for k, v in {"b": 2, "a": {"c": n}} }}{{ k }}{{ endfor }}`, "ab"},
+		{`{{ {"a": {"b": n}}["a"]["b"] }}`, "2"},
+		{`{{"x" + "}"}}`, "x}"},
+		{`{{ "}}" + "{" }}`, "}}{"},
+		{`{{ '}' }}`, "}"},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+type tagBase struct {
+	ID int `json:"id"`
+}
+
+type tagUser struct {
+	tagBase
+	CreatedAt string `json:"created_at,omitempty" nbfmt:"created"`
+	Name      string `json:"name"`
+	Password  string `json:"-"`
+	Email     string
+	Label     string `json:"Name"`
+}
+
+func TestFieldNaming(t *testing.T) {
+	env := map[string]interface{}{
+		"u": &tagUser{tagBase{7}, "2020-01-01", "bob", "secret", "b@x.com", "label"},
+	}
+	tests := []struct {
+		src  string
+		opts []Option
+		want string
+	}{
+		{`{{ u.CreatedAt }}`, nil, "2020-01-01"},
+		{`{{ u.created_at }}`, []Option{FieldTag("json")}, "2020-01-01"},
+		{`{{ u.CreatedAt }}`, []Option{FieldTag("json")}, "2020-01-01"},
+		{`{{ u.id }}`, []Option{FieldTag("json")}, "7"},
+		{`{{ u.Name }}`, []Option{FieldTag("json")}, "label"},
+		{`{{ u.name }}`, []Option{FieldTag("json")}, "bob"},
+		{`{{ u.Password }}`, []Option{FieldTag("json")}, "secret"},
+		{`{{ u.Email }}`, []Option{FieldTag("json")}, "b@x.com"},
+		{`{{ u.created }}`, []Option{FieldTag("nbfmt")}, "2020-01-01"},
+		{`{{ u.email }}`, []Option{CaseInsensitiveFields()}, "b@x.com"},
+		{`{{ u.CREATED_AT }}`, []Option{FieldTag("json"), CaseInsensitiveFields()}, "2020-01-01"},
+		{`{{ for v in sort([u], "created_at") }}{{ v.name }}{{ endfor }}`, []Option{FieldTag("json")}, "bob"},
+	}
+	for _, test := range tests {
+		for i := 0; i < 2; i++ {
+			got, err := Fmt(test.src, env, test.opts...)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.src, err)
+				break
+			}
+			if got != test.want {
+				t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+				break
+			}
+		}
+	}
+	for _, test := range []struct {
+		src  string
+		opts []Option
+	}{
+		{`{{ u.created_at }}`, nil},
+		{`{{ u.email }}`, []Option{FieldTag("json")}},
+		{`{{ u.created_at }}`, []Option{FieldTag("nbfmt")}},
+		{`{{ u.tagBase }}`, []Option{CaseInsensitiveFields()}},
+	} {
+		if _, err := Fmt(test.src, env, test.opts...); err == nil {
+			t.Errorf("%s: expected error", test.src)
+		}
+	}
+}
+
+type rootData struct {
+	Title string
+	Items []string
+	Owner *tagUser
+	count int
+}
+
+func (r rootData) Upper(s string) string {
+	return strings.ToUpper(s)
+}
+
+func (r *rootData) Count() int {
+	return len(r.Items)
+}
+
+type rootNamed struct {
+	Name string
+}
+
+type rootLabel struct {
+	Name string
+}
+
+type rootEmbed struct {
+	*rootNamed
+	Items []string
+}
+
+type rootAmbiguous struct {
+	rootNamed
+	rootLabel
+}
+
+func TestRootData(t *testing.T) {
+	data := rootData{Title: "list", Items: []string{"a", "b"}, Owner: &tagUser{Name: "bob"}}
+	tests := []struct {
+		src  string
+		data interface{}
+		opts []Option
+		want string
+	}{
+		{`{{ Title }}:{{ for v in Items }}{{ v }}{{ endfor }}`, data, nil, "list:ab"},
+		{`{{ Title }}:{{ Owner.Name }}`, &data, nil, "list:bob"},
+		{`{{ Upper(Title) }}`, data, nil, "LIST"},
+		{`{{ Count() }}`, &data, nil, "2"},
+		{`{{ for Title in Items }}{{ Title }}{{ endfor }}{{ Title }}`, data, nil, "ablist"},
+		{`{{ owner.name }}`, data, []Option{FieldTag("json"), CaseInsensitiveFields()}, "bob"},
+		{`{{ a }}{{ b }}`, map[string]string{"a": "x", "b": "y"}, nil, "xy"},
+		{`{{ a.b }}`, map[string]map[string]int{"a": {"b": 1}}, nil, "1"},
+		{`{{ len(l) }}`, map[string]interface{}{"l": []int{1}, "len": func(l []int) int { return len(l) }}, nil, "1"},
+		{`{{ for i in range(2) }}{{ i }}{{ endfor }}`, nil, nil, "01"},
+		{`{{ Title }}`, struct{ Title string }{"anonymous"}, nil, "anonymous"},
+		{`[{{ Missing }}]`, data, []Option{Missing(MissingEmpty)}, "[]"},
+		{`[{{ Missing }}]{{ for i in range(2) }}{{ i }}{{ endfor }}`, (*rootData)(nil), []Option{Missing(MissingEmpty)}, "[]01"},
+		{`{{ for v in reverse(Items) }}{{ v }}{{ endfor }}`, rootEmbed{Items: []string{"a", "b"}}, nil, "ba"},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, test.data, test.opts...)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, test := range []struct {
+		src  string
+		data interface{}
+	}{
+		{`{{ count }}`, data},
+		{`{{ Count() }}`, data},
+		{`{{ Missing }}`, &data},
+		{`{{ Title }}`, (*rootData)(nil)},
+		{`{{ a }}`, map[int]string{1: "a"}},
+		{`{{ a }}`, 1},
+		{`{{ a }}`, []string{"a"}},
+	} {
+		if _, err := Fmt(test.src, test.data); err == nil {
+			t.Errorf("%s: expected error", test.src)
+		}
+	}
+	// the errors of the fields of the root data are not missing variables
+	for _, test := range []struct {
+		src  string
+		data interface{}
+		want string
+	}{
+		{`{{ count }}`, data, "unexported"},
+		{`{{ Name }}`, rootEmbed{}, "nil embedded"},
+		{`{{ Name }}`, rootAmbiguous{}, "ambiguous"},
+		{`{{ Title }}`, (*rootData)(nil), "nil pointer"},
+		{`{{ a }}`, (*int)(nil), "must be struct"},
+		{`{{ a }}`, (*[]string)(nil), "must be struct"},
+		{`{{ a }}`, (*map[string]int)(nil), "must be struct"},
+	} {
+		_, err := Fmt(test.src, test.data, Missing(MissingEmpty))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected error %q, got %v", test.src, test.want, err)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	env := map[string]interface{}{
+		"_targetVal": "user",
+		"v":          "outer",
+		"list":       []string{"a", "b"},
+		"empty":      "",
+		"x":          1,
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ switch x }}{{ case 1 }}{{ _targetVal }}{{ endswitch }}`, "user"},
+		{`{{ switch x }}{{ case 1 }}{{ empty }}{{ default }}default{{ endswitch }}`, ""},
+		{`{{ for v in list }}{{ v }}{{ endfor }}{{ v }}`, "abouter"},
+		{`{{ for i, v in list }}{{ for j, v in list }}{{ i }}{{ v }}{{ endfor }}{{ v }};{{ endfor }}{{ v }}`, "0a0ba;1a1bb;outer"},
+		{`{{ for v in list }}{{ switch v }}{{ case "b" }}{{ loop.index }}{{ endswitch }}{{ endfor }}`, "1"},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	if len(env) != 5 || env["v"] != "outer" {
+		t.Errorf("env is changed by execution: %v", env)
+	}
+}
+
+func TestSwitchForms(t *testing.T) {
+	env := map[string]interface{}{
+		"x":     150,
+		"s":     "pending",
+		"items": []interface{}{1, "a", 2.5, true, nil, []string{"b"}, map[string]int{}, version{1, 2}},
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ switch x }}{{ case < 0 }}negative{{ case > 100 }}big{{ default }}small{{ endswitch }}`, "big"},
+		{`{{ switch x }}{{ case 1, >= 150 }}ok{{ endswitch }}`, "ok"},
+		{`{{ switch s }}{{ case in ["open", "pending"] }}active{{ case "closed" }}closed{{ endswitch }}`, "active"},
+		{`{{ switch x }}{{ case 150 }}a{{ fallthrough }}{{ case 1 }}b{{ case 2 }}c{{ endswitch }}`, "ab"},
+		{`{{ switch x }}{{ case > 100 }}a{{ fallthrough }}
+		{{ default }}b{{ endswitch }}`, "ab"},
+		{`{{ switch x }}{{ case 1 }}a{{ fallthrough }}{{ case 2 }}b{{ default }}c{{ endswitch }}`, "c"},
+		{`{{ for v in items }}{{ switch type(v) }}{{ case int }}i{{ case float }}f{{ case string, bool }}s{{ case nil }}n{{ case []string }}l{{ case map }}m{{ default }}?{{ endswitch }}{{ endfor }}`, "isfsnlm?"},
+		{`{{ for v in items }}{{ switch type(v) }}{{ case number }}n{{ case nbfmt.version }}v{{ endswitch }}{{ endfor }}`, "nnv"},
+		// the first matched case is rendered even if its output is empty, in switch and if alike
+		{`{{ switch x }}{{ case 150 }}{{ "" }}{{ case > 100 }}B{{ endswitch }}`, ""},
+		{`{{ if true }}{{ "" }}{{ elseif true }}B{{ endif }}`, ""},
+		{`{{ if x > 100 }}{{ if false }}A{{ endif }}{{ else }}C{{ endif }}`, ""},
+		{`{{ if x < 100 }}A{{ elseif x > 10 }}{{ s[4:4] }}{{ else }}C{{ endif }}`, ""},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`{{ switch x }}{{ case 1 }}a{{ fallthrough }}{{ endswitch }}`,
+		`{{ switch x }}{{ case 1 }}{{ fallthrough }}b{{ case 2 }}c{{ endswitch }}`,
+		`{{ switch type(x) }}{{ case int }}{{ fallthrough }}{{ case string }}s{{ endswitch }}`,
+		`{{ fallthrough }}`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+	if _, err := Fmt(`{{ switch x }}{{ case + 1 }}a{{ endswitch }}`, env); err == nil {
+		t.Errorf("expected error for a case which is not bool")
+	}
+}
+
+type version struct {
+	major, minor int
+}
+
+func (v version) Compare(other interface{}) int {
+	o := other.(version)
+	if v.major != o.major {
+		return v.major - o.major
+	}
+	return v.minor - o.minor
+}
+
+func (v version) String() string {
+	return fmt.Sprintf("v%d.%d", v.major, v.minor)
+}
+
+func TestComparableMixedTypes(t *testing.T) {
+	env := map[string]interface{}{"v": version{1, 2}, "w": version{1, 2}}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ v == 1 }}`, "false"},
+		{`{{ 1 != v }}`, "true"},
+		{`{{ v == w }}`, "true"},
+		{`{{ v in [1, "a", nil] }}`, "false"},
+		{`{{ switch v }}{{ case 1 }}a{{ case w }}b{{ endswitch }}`, "b"},
+		{`{{ for x in unique([1, v, w, "v1.2"]) }}{{ loop.length }}{{ endfor }}`, "333"},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{`{{ v < 1 }}`, `{{ "a" >= v }}`} {
+		if _, err := Fmt(src, env); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestMapOrder(t *testing.T) {
+	env := map[string]interface{}{
+		"strs":     map[string]int{"b": 1, "c": 3, "a": 2, "d": 2},
+		"ints":     map[int]string{10: "x", 2: "y", -1: "z", 7: "w"},
+		"versions": map[version]string{{1, 10}: "c", {1, 2}: "b", {0, 9}: "a"},
+		"ordered":  MapSlice{{"z", 1}, {"a", 3}, {"m", 2}},
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ for k, v in strs }}{{ k }}{{ v }} {{ endfor }}`, "a2 b1 c3 d2 "},
+		{`{{ for k, v in strs desc }}{{ k }}{{ v }} {{ endfor }}`, "d2 c3 b1 a2 "},
+		{`{{ for k, v in strs byvalue }}{{ k }}{{ v }} {{ endfor }}`, "b1 a2 d2 c3 "},
+		{`{{ for k, v in strs byvalue desc }}{{ k }}{{ v }} {{ endfor }}`, "c3 d2 a2 b1 "},
+		{`{{ for k, v in ints }}{{ k }} {{ endfor }}`, "-1 2 7 10 "},
+		{`{{ for k, v in versions }}{{ v }}{{ endfor }}`, "abc"},
+		{`{{ for k, v in ordered }}{{ k }}{{ v }} {{ endfor }}`, "z1 a3 m2 "},
+		{`{{ for k, v in ordered desc }}{{ k }} {{ endfor }}`, "z m a "},
+		{`{{ for k, v in ordered byvalue }}{{ k }} {{ endfor }}`, "z m a "},
+		{`{{ for v in strs }}{{ v }}{{ endfor }}`, "2132"},
+	}
+	for _, test := range tests {
+		for i := 0; i < 5; i++ {
+			got, err := Fmt(test.src, env)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.src, err)
+				break
+			}
+			if got != test.want {
+				t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+				break
+			}
+		}
+	}
+	for _, src := range []string{
+		`{{ for k, v in strs asc }}a{{ endfor }}`,
+		`{{ for i in range(3) desc }}a{{ endfor }}`,
+	} {
+		if _, err := Fmt(src, env); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestMissing(t *testing.T) {
+	env := map[string]interface{}{
+		"user":  map[string]interface{}{"Name": "Ann", "Nick": nil, "Tags": map[string]string{}},
+		"empty": "",
+		"n":     0,
+	}
+	data := rootData{Title: "list"}
+	tests := []struct {
+		src  string
+		data interface{}
+		opts []Option
+		want string
+	}{
+		{`{{ defined(user) }} {{ defined(x) }} {{ defined(user.Name) }} {{ defined(user.Age) }} {{ defined(user["Age"]) }}`, nil, nil, "true false true false false"},
+		{`{{ defined(user.Nick) }} {{ defined(x.y.z) }} {{ defined(user?.Tags?.a) }} {{ defined(user.Tags.a.b) }}`, nil, nil, "true false false false"},
+		{`{{ if defined(x) && x > 1 }}big{{ else }}small{{ endif }}`, nil, nil, "small"},
+		{`{{ default(x, "anon") }} {{ default(user.Name, "anon") }} {{ default(user.Nick, "anon") }} {{ default(empty, "-") }} {{ default(n, 1) }}`, nil, nil, "anon Ann anon - 0"},
+		{`{{ default(x, default(y, "z")) }} {{ default(user.Name, x) }}`, nil, nil, "z Ann"},
+		{`{{ switch default(x, 2) }}{{ case 1 }}one{{ default }}other{{ endswitch }}`, nil, nil, "other"},
+		{`{{ defined(Title) }} {{ defined(Items) }} {{ default(Missing, Title) }}`, data, nil, "true true list"},
+		{`[{{ x }}][{{ user.Age }}][{{ user["Age"] }}]`, nil, []Option{Missing(MissingZero)}, "[nil][nil][nil]"},
+		{`{{ if x == nil }}none{{ endif }} {{ defined(x) }}`, nil, []Option{Missing(MissingZero)}, "none false"},
+		{`[{{ x }}][{{ user.Age }}][{{ x + "!" }}]`, nil, []Option{Missing(MissingEmpty)}, "[][][!]"},
+		{`Hi {{ user.Name }}, {{ user.Age + 1 }} {{ x }} {{ default(x, "-") }}`, nil, []Option{Missing(MissingKeep)}, "Hi Ann, {{ user.Age + 1 }} {{ x }} -"},
+		{`{{ defined("x") }} {{ default(" y ") }}`, map[string]interface{}{"defined": strings.ToUpper, "default": strings.TrimSpace}, nil, "X y"},
+	}
+	for _, test := range tests {
+		data := test.data
+		if data == nil {
+			data = env
+		}
+		got, err := Fmt(test.src, data, test.opts...)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, test := range []struct {
+		src  string
+		opts []Option
+	}{
+		{`{{ x }}`, nil},
+		{`{{ user.Age }}`, nil},
+		{`{{ user.Name.First }}`, []Option{Missing(MissingEmpty)}},
+		{`{{ x.y }}`, []Option{Missing(MissingZero)}},
+		{`{{ if x }}a{{ endif }}`, []Option{Missing(MissingKeep)}},
+		{`{{ defined(1 / 0) }}`, nil},
+	} {
+		if got, err := Fmt(test.src, env, test.opts...); err == nil {
+			t.Errorf("%s: expected error, got %q", test.src, got)
+		}
+	}
+}
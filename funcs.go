@@ -0,0 +1,296 @@
+package nbfmt
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//builtins are the functions which can be called in all templates, the variables in env with the same name take precedence
+var builtins = map[string]interface{}{
+	"range":    rangeFunc,
+	"sort":     sortFunc,
+	"groupby":  groupbyFunc,
+	"unique":   uniqueFunc,
+	"reverse":  reverseFunc,
+	"batch":    batchFunc,
+	"slice":    sliceFunc,
+	"date":     dateFunc,
+	"tz":       tzFunc,
+	"ago":      agoFunc,
+	"duration": durationFunc,
+	"now":      nowFunc,
+	"startOf":  startOfFunc,
+	"addDate":  addDateFunc,
+	"days":     daysFunc,
+	"number":   numberFunc,
+	"currency": currencyFunc,
+	"percent":  percentFunc,
+	"compact":  compactFunc,
+}
+
+//intRange is a sequence of integers from start (inclusive) to stop (exclusive) by step,
+//it is iterated by for block without allocating the numbers
+type intRange struct {
+	start int64
+	stop  int64
+	step  int64
+}
+
+func (r intRange) len() int64 {
+	switch {
+	case r.step > 0 && r.start < r.stop:
+		return (r.stop - r.start + r.step - 1) / r.step
+	case r.step < 0 && r.start > r.stop:
+		return (r.start - r.stop - r.step - 1) / -r.step
+	default:
+		return 0
+	}
+}
+
+func (r intRange) at(i int64) int64 {
+	return r.start + i*r.step
+}
+
+//rangeFunc is range(stop), range(start, stop) or range(start, stop, step) like python
+func rangeFunc(args ...int64) (intRange, error) {
+	switch len(args) {
+	case 1:
+		return intRange{0, args[0], 1}, nil
+	case 2:
+		return intRange{args[0], args[1], 1}, nil
+	case 3:
+		if args[2] == 0 {
+			return intRange{}, errors.New("nbfmt.rangeFunc() error: step of range cannot be zero")
+		}
+		return intRange{args[0], args[1], args[2]}, nil
+	default:
+		return intRange{}, fmt.Errorf("nbfmt.rangeFunc() error: range takes 1 to 3 arguments (%d supplied)", len(args))
+	}
+}
+
+//closedRange is the result of start..stop, both start and stop are included and it counts down if start is greater than stop
+func closedRange(lv, rv interface{}) (interface{}, error) {
+	start, stop, ok := assertToInt(lv, rv)
+	if !ok {
+		return nil, fmt.Errorf("nbfmt.closedRange() error: cannot make range of (%T and %T)\n", lv, rv)
+	}
+	if start <= stop {
+		return intRange{start, stop + 1, 1}, nil
+	}
+	return intRange{start, stop - 1, -1}, nil
+}
+
+//toList returns the elements of slice, array, string, range or integer, and the values of map (ordered by keys) as a list
+func toList(obj interface{}) ([]interface{}, error) {
+	if sorted, ok := sortMap(obj, false, false); ok {
+		obj = sorted
+	}
+	length, ok := iterLen(obj)
+	if !ok || length < 0 {
+		return nil, fmt.Errorf("nbfmt.toList() error: %T is not a list or a map", obj)
+	}
+	l := make([]interface{}, 0, length)
+	each(obj, func(key, val interface{}) bool {
+		l = append(l, val)
+		return true
+	})
+	return l, nil
+}
+
+//attr returns the field of obj by path, the nested fields are separated by dots (e.g. "Inner.Value"),
+//obj itself is returned if path is empty
+func attr(sc *scope, obj interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return obj, nil
+	}
+	for _, name := range strings.Split(path, ".") {
+		v, err := field(obj, &ident{src: name, typ: varIdent}, sc.opts)
+		if err != nil {
+			return nil, err
+		}
+		obj = v
+	}
+	return obj, nil
+}
+
+//sortFunc is sort(list), sort(list, "Field") or sort(list, "Field", true), the elements are ordered by the field
+//(or by themselves if the field is omitted) ascending, the last bool argument reverses the order
+func sortFunc(sc *scope, list interface{}, args ...interface{}) ([]interface{}, error) {
+	var path string
+	var desc bool
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case string:
+			if i != 0 {
+				return nil, fmt.Errorf("nbfmt.sortFunc() error: the field must be the second argument of sort (%s)", a)
+			}
+			path = a
+		case bool:
+			if i != len(args)-1 {
+				return nil, errors.New("nbfmt.sortFunc() error: the order must be the last argument of sort")
+			}
+			desc = a
+		default:
+			return nil, fmt.Errorf("nbfmt.sortFunc() error: invalid argument of sort (%v)", arg)
+		}
+	}
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, len(l))
+	for i, v := range l {
+		if keys[i], err = attr(sc, v, path); err != nil {
+			return nil, err
+		}
+	}
+	idx := make([]int, len(l))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		c := compareValues(keys[idx[i]], keys[idx[j]])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	result := make([]interface{}, len(l))
+	for i, j := range idx {
+		result[i] = l[j]
+	}
+	return result, nil
+}
+
+//groupbyFunc is groupby(list, "Field"), it groups the elements by the field, the result is a MapSlice of which the keys are the values of the field
+//in ascending order and the values are the lists of the elements in their original order
+func groupbyFunc(sc *scope, list interface{}, path string) (MapSlice, error) {
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	var groups MapSlice
+OUTER:
+	for _, v := range l {
+		key, err := attr(sc, v, path)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("nbfmt.groupbyFunc() error: %s (%T) cannot be used as a group key", path, key)
+		}
+		// the keys are matched by same like ==, so 1 and 1.0 are in the same group
+		for i := range groups {
+			if same(groups[i].Key, key) {
+				groups[i].Value = append(groups[i].Value.([]interface{}), v)
+				continue OUTER
+			}
+		}
+		groups = append(groups, MapItem{key, []interface{}{v}})
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return compareValues(groups[i].Key, groups[j].Key) < 0
+	})
+	return groups, nil
+}
+
+//uniqueFunc is unique(list) or unique(list, "Field"), only the first one of the elements which are equal
+//(or have the equal field) by == is kept
+func uniqueFunc(sc *scope, list interface{}, path ...string) ([]interface{}, error) {
+	if len(path) > 1 {
+		return nil, fmt.Errorf("nbfmt.uniqueFunc() error: unique takes 1 or 2 arguments (%d supplied)", len(path)+1)
+	}
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	var p string
+	if len(path) == 1 {
+		p = path[0]
+	}
+	result := make([]interface{}, 0, len(l))
+	var seen []interface{}
+OUTER:
+	for _, v := range l {
+		key, err := attr(sc, v, p)
+		if err != nil {
+			return nil, err
+		}
+		// same is the equality of ==, it compares numbers by value and never panics
+		for _, s := range seen {
+			if same(s, key) {
+				continue OUTER
+			}
+		}
+		seen = append(seen, key)
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+//reverseFunc returns the elements of list in reverse order
+func reverseFunc(list interface{}) ([]interface{}, error) {
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(l)-1; i < j; i, j = i+1, j-1 {
+		l[i], l[j] = l[j], l[i]
+	}
+	return l, nil
+}
+
+//batchFunc is batch(list, n) or batch(list, n, fill), it splits list into rows of n elements,
+//the last row is filled up with fill if it is given
+func batchFunc(list interface{}, n int64, fill ...interface{}) ([][]interface{}, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("nbfmt.batchFunc() error: size of batch must be positive (%d)", n)
+	}
+	if len(fill) > 1 {
+		return nil, fmt.Errorf("nbfmt.batchFunc() error: batch takes 2 or 3 arguments (%d supplied)", len(fill)+2)
+	}
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]interface{}
+	for start := 0; start < len(l); start += int(n) {
+		end := start + int(n)
+		if end > len(l) {
+			end = len(l)
+		}
+		row := l[start:end:end]
+		for len(fill) == 1 && len(row) < int(n) {
+			row = append(row, fill[0])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+//sliceFunc is slice(list, n), it splits list into n columns, the first columns have one more element
+//than the others if the elements cannot be divided equally
+func sliceFunc(list interface{}, n int64) ([][]interface{}, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("nbfmt.sliceFunc() error: number of slices must be positive (%d)", n)
+	}
+	l, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	size, extra := len(l)/int(n), len(l)%int(n)
+	columns := make([][]interface{}, 0, n)
+	start := 0
+	for i := 0; i < int(n); i++ {
+		end := start + size
+		if i < extra {
+			end++
+		}
+		columns = append(columns, l[start:end:end])
+		start = end
+	}
+	return columns, nil
+}
@@ -0,0 +1,95 @@
+package nbfmt
+
+import (
+	"testing"
+)
+
+type groupKey struct {
+	V interface{}
+}
+
+type product struct {
+	Name     string
+	Category string
+	Price    int
+	Inner    exprInner
+}
+
+func TestCollectionFuncs(t *testing.T) {
+	env := map[string]interface{}{
+		"products": []product{
+			{"pen", "office", 3, exprInner{2}},
+			{"apple", "food", 5, exprInner{3}},
+			{"paper", "office", 1, exprInner{1}},
+			{"bread", "food", 5, exprInner{0}},
+			{"tape", "office", 2, exprInner{5}},
+		},
+		"nums":   []int{3, 1, 2, 3, 1},
+		"arr":    [3]string{"c", "a", "b"},
+		"prices": map[string]int{"b": 2, "a": 3, "c": 1},
+		"tags":   [][]string{{"a"}, {"b"}, {"a"}},
+		"mixed":  []interface{}{1, 1.0, int64(1), int8(2), uint(2), 2.5, "1"},
+		"boxes":  []interface{}{[]int{1}, []interface{}{1.0}, []int{2}, map[string]int{"a": 1}, map[string]float64{"a": 1}},
+		"rows": []map[string]interface{}{
+			{"Name": "a", "Qty": 1, "Key": groupKey{[]int{1}}},
+			{"Name": "b", "Qty": 1.0, "Key": groupKey{[]int{2}}},
+			{"Name": "c", "Qty": int64(2), "Key": groupKey{[]int{1}}},
+		},
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ for v in sort(nums) }}{{ v }}{{ endfor }}`, "11233"},
+		{`{{ for v in sort(nums, true) }}{{ v }}{{ endfor }}`, "33211"},
+		{`{{ for v in sort(arr) }}{{ v }}{{ endfor }}`, "abc"},
+		{`{{ for v in sort(products, "Price") }}{{ v.Name }} {{ endfor }}`, "paper tape pen apple bread "},
+		{`{{ for v in sort(products, "Price", true) }}{{ v.Name }} {{ endfor }}`, "apple bread pen tape paper "},
+		{`{{ for v in sort(products, "Inner.Value") }}{{ v.Name }} {{ endfor }}`, "bread paper pen apple tape "},
+		{`{{ for v in sort(prices) }}{{ v }}{{ endfor }}`, "123"},
+		{`{{ for k, g in groupby(products, "Category") }}{{ k }}:{{ for v in g }} {{ v.Name }}{{ endfor }};{{ endfor }}`, "food: apple bread;office: pen paper tape;"},
+		{`{{ for k, g in groupby(products, "Price") }}{{ k }}={{ loop.length }}/{{ for v in g }}{{ loop.length }}{{ endfor }} {{ endfor }}`, "1=4/1 2=4/1 3=4/1 5=4/22 "},
+		{`{{ for v in unique(nums) }}{{ v }}{{ endfor }}`, "312"},
+		{`{{ for v in unique(products, "Category") }}{{ v.Name }} {{ endfor }}`, "pen apple "},
+		{`{{ for v in unique(tags) }}{{ v[0] }}{{ endfor }}`, "ab"},
+		{`{{ for v in unique(mixed) }}{{ v }} {{ endfor }}`, "1 2 2.500000 1 "},
+		{`{{ for v in unique(boxes) }}{{ loop.length }}{{ endfor }}`, "333"},
+		{`{{ for v in unique(boxes[:3]) }}{{ v[0] }}{{ endfor }}`, "12"},
+		{`{{ for v in unique(rows, "Qty") }}{{ v.Name }}{{ endfor }}`, "ac"},
+		{`{{ for v in unique(rows, "Key") }}{{ v.Name }}{{ endfor }}`, "ab"},
+		{`{{ for k, g in groupby(rows, "Qty") }}{{ k }}:{{ for v in g }}{{ v.Name }}{{ endfor }} {{ endfor }}`, "1:ab 2:c "},
+		{`{{ for k, g in groupby(rows, "Key") }}{{ for v in g }}{{ v.Name }}{{ endfor }} {{ endfor }}`, "ac b "},
+		{`{{ for v in reverse(nums) }}{{ v }}{{ endfor }}`, "13213"},
+		{`{{ for v in reverse(prices) }}{{ v }}{{ endfor }}`, "123"},
+		{`{{ for v in reverse(1..3) }}{{ v }}{{ endfor }}`, "321"},
+		{`{{ for row in batch(nums, 2) }}[{{ for v in row }}{{ v }}{{ endfor }}]{{ endfor }}`, "[31][23][1]"},
+		{`{{ for row in batch(nums, 2, 0) }}[{{ for v in row }}{{ v }}{{ endfor }}]{{ endfor }}`, "[31][23][10]"},
+		{`{{ for col in slice(nums, 2) }}[{{ for v in col }}{{ v }}{{ endfor }}]{{ endfor }}`, "[312][31]"},
+		{`{{ for col in slice(arr, 4) }}[{{ for v in col }}{{ v }}{{ endfor }}]{{ endfor }}`, "[c][a][b][]"},
+		{`{{ for v in sort(unique(nums), true) }}{{ v }}{{ endfor }}`, "321"},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`{{ sort(1.5) }}`,
+		`{{ sort(products, "Missing") }}`,
+		`{{ sort(nums, true, "Name") }}`,
+		`{{ groupby(products) }}`,
+		`{{ groupby(tags, "") }}`,
+		`{{ unique(nums, "a", "b") }}`,
+		`{{ batch(nums, 0) }}`,
+		`{{ slice(nums, -1) }}`,
+	} {
+		if _, err := Fmt(src, env); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
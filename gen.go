@@ -0,0 +1,582 @@
+package nbfmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//GenerateGo compiles tmpl into a Go source file of package pkg, the file contains a function
+//
+//	func funcName(w io.Writer, env envType) error
+//
+//which writes the rendered template to w. If envType is a map type the top level variables are
+//read by env["name"], otherwise they are read as fields (env.Name), so the names in template must be
+//the exported field names. Because the generated code is plain Go, type errors in the template
+//are reported by go build instead of at rendering time. envType must be a type literal here
+//(e.g. map[string]string or struct{ Items []string }), GenerateGoPackage resolves the types declared in a package.
+func GenerateGo(tmpl *Template, pkg, funcName, envType string) ([]byte, error) {
+	if !token.IsIdentifier(pkg) {
+		return nil, fmt.Errorf("nbfmt.GenerateGo() error: invalid package name (%s)", pkg)
+	}
+	return generateGo(tmpl, token.NewFileSet(), types.NewPackage(pkg, pkg), funcName, envType)
+}
+
+//GenerateGoPackage is GenerateGo for the Go package in dir, the generated file belongs to the package and envType
+//can be a type declared in it (e.g. ReportData). The package is type-checked from source to know which loops
+//iterate slices, maps, strings or integers, the files generated by nbfmt are skipped
+func GenerateGoPackage(tmpl *Template, dir, funcName, envType string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkg, err := loadPackage(fset, dir)
+	if err != nil {
+		return nil, err
+	}
+	return generateGo(tmpl, fset, pkg, funcName, envType)
+}
+
+func generateGo(tmpl *Template, fset *token.FileSet, pkg *types.Package, funcName, envType string) ([]byte, error) {
+	if !token.IsIdentifier(funcName) {
+		return nil, fmt.Errorf("nbfmt.GenerateGo() error: invalid function name (%s)", funcName)
+	}
+	if tmpl.opts.fieldTag != "" || tmpl.opts.foldFieldCase {
+		return nil, errors.New("nbfmt.GenerateGo() error: FieldTag and CaseInsensitiveFields are not supported, the generated code uses the Go names of fields")
+	}
+	tv, err := types.Eval(fset, pkg, token.NoPos, envType)
+	if err != nil || !tv.IsType() {
+		return nil, fmt.Errorf("nbfmt.GenerateGo() error: unknown env type (%s)", envType)
+	}
+	_, envIsMap := tv.Type.Underlying().(*types.Map)
+	g := &goGen{
+		fset:      fset,
+		pkg:       pkg,
+		envType:   tv.Type,
+		envIsMap:  envIsMap,
+		imports:   map[string]bool{"fmt": true, "io": true, "time": true},
+		valueFunc: "write" + strings.ToUpper(funcName[:1]) + funcName[1:] + "Value",
+		loopType:  strings.ToLower(funcName[:1]) + funcName[1:] + "Loop",
+		rangeFunc: strings.ToLower(funcName[:1]) + funcName[1:] + "Range",
+	}
+	g.printf("func %s(w io.Writer, env %s) error {\n", funcName, envType)
+	for _, b := range tmpl.blocks {
+		if err := g.genBlock(b); err != nil {
+			return nil, err
+		}
+	}
+	g.printf("return nil\n}\n\n")
+	g.genValueFunc()
+	if g.usesLoop {
+		g.genLoopType()
+	}
+	if g.usesRange {
+		g.genRangeFunc()
+	}
+	// the imports are known after the function is generated
+	imports := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		imports = append(imports, strconv.Quote(path))
+	}
+	sort.Strings(imports)
+	var file bytes.Buffer
+	fmt.Fprintf(&file, "%s\n\npackage %s\n\nimport (\n%s\n)\n\n", generatedHeader, pkg.Name(), strings.Join(imports, "\n"))
+	file.Write(g.buf.Bytes())
+	src, err := format.Source(file.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("nbfmt.GenerateGo() error: generated invalid go source (%v)", err)
+	}
+	return src, nil
+}
+
+type goGen struct {
+	buf bytes.Buffer
+	//fset and pkg are where the Go expressions of the generated function are type-checked, pos is the position
+	//of the last scope of type-checking
+	fset      *token.FileSet
+	pkg       *types.Package
+	pos       int
+	envType   types.Type
+	envIsMap  bool
+	imports   map[string]bool
+	valueFunc string
+	loopType  string
+	usesLoop  bool
+	rangeFunc string
+	usesRange bool
+	locals    []map[string]string
+	//varTypes are the types of the Go variables of locals, nil if the type is unknown
+	varTypes []map[string]types.Type
+	//labels are all the labels of for statements, loops are the labels of for statements which contain the current block
+	labels []*goLabel
+	loops  []*goLabel
+}
+
+type goLabel struct {
+	name string
+	used bool
+}
+
+func (g *goGen) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *goGen) pushLocals() {
+	g.locals = append(g.locals, make(map[string]string))
+	g.varTypes = append(g.varTypes, make(map[string]types.Type))
+}
+
+func (g *goGen) popLocals() {
+	g.locals = g.locals[:len(g.locals)-1]
+	g.varTypes = g.varTypes[:len(g.varTypes)-1]
+}
+
+//declare binds a template variable to a Go variable of type typ, the names used by the generated function are renamed
+func (g *goGen) declare(name string, typ types.Type) string {
+	goName := name
+	switch {
+	case token.Lookup(name).IsKeyword(), name == "w", name == "env", name == "err", name == "_":
+		goName = name + "_"
+	}
+	g.locals[len(g.locals)-1][name] = goName
+	g.varTypes[len(g.varTypes)-1][goName] = typ
+	return goName
+}
+
+func (g *goGen) lookup(name string) (string, bool) {
+	for i := len(g.locals) - 1; i >= 0; i-- {
+		if goName, ok := g.locals[i][name]; ok {
+			return goName, true
+		}
+	}
+	return "", false
+}
+
+func (g *goGen) genBlocks(l []block) error {
+	for _, b := range l {
+		if err := g.genBlock(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *goGen) genBlock(b block) error {
+	switch blk := b.(type) {
+	case *tempBlock:
+		if blk.src == "" {
+			return nil
+		}
+		g.printf("if _, err := io.WriteString(w, %s); err != nil {\nreturn err\n}\n", strconv.Quote(blk.src))
+	case *valueBlock:
+		e, err := g.genExpr(blk.exp)
+		if err != nil {
+			return err
+		}
+		g.printf("if err := %s(w, %s); err != nil {\nreturn err\n}\n", g.valueFunc, e)
+	case *ifBlock:
+		for i, cb := range blk.caseBlocks {
+			cond, err := g.genExpr(cb.exp)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				g.printf("if %s {\n", cond)
+			} else {
+				g.printf("} else if %s {\n", cond)
+			}
+			if err := g.genBlocks(cb.subBlocks); err != nil {
+				return err
+			}
+		}
+		if blk.defaultBlock != nil {
+			g.printf("} else {\n")
+			if err := g.genBlocks(blk.defaultBlock.subBlocks); err != nil {
+				return err
+			}
+		}
+		g.printf("}\n")
+	case *switchBlock:
+		if blk.typeSwitch {
+			return fmt.Errorf("nbfmt.GenerateGo() error: type switch is not supported (%s)", blk.stmt.src)
+		}
+		tar, err := g.genExpr(blk.exp)
+		if err != nil {
+			return err
+		}
+		g.printf("switch %s {\n", tar)
+		for _, cb := range blk.caseBlocks {
+			l := make([]string, 0, len(cb.exps))
+			for i, e := range cb.exps {
+				if cb.ops[i] != nil {
+					return fmt.Errorf("nbfmt.GenerateGo() error: predicate case is not supported (%s)", cb.stmt.src)
+				}
+				s, err := g.genExpr(e)
+				if err != nil {
+					return err
+				}
+				l = append(l, s)
+			}
+			g.printf("case %s:\n", strings.Join(l, ", "))
+			if err := g.genBlocks(cb.subBlocks); err != nil {
+				return err
+			}
+			if cb.fallsThrough {
+				g.printf("fallthrough\n")
+			}
+		}
+		if blk.defaultBlock != nil {
+			g.printf("default:\n")
+			if err := g.genBlocks(blk.defaultBlock.subBlocks); err != nil {
+				return err
+			}
+		}
+		g.printf("}\n")
+	case *loopCtrlBlock:
+		label := g.loops[len(g.loops)-1]
+		label.used = true
+		g.printf("%s %s\n", blk.stmt.idents[0].src, label.name)
+	case *forBlock:
+		return g.genFor(blk)
+	default:
+		return fmt.Errorf("nbfmt.GenerateGo() error: unsupported block (%s)", b.getSrc())
+	}
+	return nil
+}
+
+//genFor writes the for block, the loop statement depends on the type of the object: slices and arrays are ranged,
+//maps are ranged by the ascending keys like for block, strings are ranged by runes and an integer n is counted
+//from 0 to n-1. Channels and iterator functions are not supported, because loop.length is unknown before ranging them
+func (g *goGen) genFor(blk *forBlock) error {
+	if blk.desc || blk.byValue || blk.filter != nil {
+		return fmt.Errorf("nbfmt.GenerateGo() error: desc, byvalue and if clause are not supported (%s)", blk.stmt.src)
+	}
+	obj, err := g.genExpr(blk.objExpr)
+	if err != nil {
+		return err
+	}
+	typ, err := g.typeOf(obj)
+	if err != nil {
+		return fmt.Errorf("nbfmt.GenerateGo() error: cannot resolve the type of (%s): %v", blk.stmt.src, err)
+	}
+	var idxType, valType types.Type
+	var counted, keyed, runes bool
+	switch u := typ.Underlying().(type) {
+	case *types.Map:
+		if b, ok := u.Key().Underlying().(*types.Basic); !ok || b.Info()&types.IsOrdered == 0 {
+			return fmt.Errorf("nbfmt.GenerateGo() error: the keys of %s cannot be sorted in generated code (%s)", typ, blk.stmt.src)
+		}
+		idxType, valType, keyed = u.Key(), u.Elem(), true
+	case *types.Slice:
+		idxType, valType = types.Typ[types.Int], u.Elem()
+	case *types.Array:
+		idxType, valType = types.Typ[types.Int], u.Elem()
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsInteger != 0:
+			idxType, valType, counted = types.Typ[types.Int], types.Typ[types.Int], true
+		case u.Info()&types.IsString != 0:
+			idxType, valType, runes = types.Typ[types.Int], types.Typ[types.String], true
+		}
+	}
+	if idxType == nil && !keyed {
+		return fmt.Errorf("nbfmt.GenerateGo() error: cannot iterate %s in generated code, it must be a slice, array, map, string or integer (%s)", typ, blk.stmt.src)
+	}
+	parent, ok := g.lookup("loop")
+	if !ok {
+		parent = "nil"
+	}
+	g.usesLoop = true
+	g.pushLocals()
+	defer g.popLocals()
+	loop := g.declare("loop", nil)
+	idx, val := "_", g.declare(blk.valueVarName, valType)
+	if blk.indexVarName != "" {
+		idx = g.declare(blk.indexVarName, idxType)
+	}
+	n := len(g.labels)
+	iter := fmt.Sprintf("iter%d", n)
+	g.printf("{\n%s := %s\n", iter, obj)
+	length := fmt.Sprintf("len(%s)", iter)
+	if runes {
+		// the elements of string are runes like Execute, rather than bytes
+		g.imports["unicode/utf8"] = true
+		length = fmt.Sprintf("utf8.RuneCountInString(%s)", iter)
+	}
+	if counted {
+		// a negative count is the same as 0
+		length = fmt.Sprintf("length%d", n)
+		g.printf("%s := int(%s)\nif %[1]s < 0 {\n%[1]s = 0\n}\n", length, iter)
+	}
+	g.printf("%s := &%s{index: -1, length: %s, parent: %s}\n", loop, g.loopType, length, parent)
+	key, keys := fmt.Sprintf("key%d", n), fmt.Sprintf("keys%d", n)
+	if keyed {
+		// Go ranges maps in random order, the keys are sorted so the output is the same as Execute
+		g.imports["sort"] = true
+		g.printf("%s := make([]%s, 0, len(%s))\n", keys, g.typeString(idxType), iter)
+		g.printf("for %s := range %s {\n%s = append(%s, %[1]s)\n}\n", key, iter, keys, keys)
+		g.printf("sort.Slice(%[1]s, func(i, j int) bool {\nreturn %[1]s[i] < %[1]s[j]\n})\n", keys)
+	}
+	// the label is inserted only if break or continue uses it, because unused label does not compile
+	label := &goLabel{name: fmt.Sprintf("loop%d", n)}
+	pos := g.buf.Len()
+	g.labels = append(g.labels, label)
+	g.loops = append(g.loops, label)
+	switch {
+	case keyed:
+		g.printf("for _, %s := range %s {\n%s.next()\n%s := %s[%[1]s]\n", key, keys, loop, val, iter)
+		if idx != "_" {
+			g.printf("%s := %s\n", idx, key)
+		}
+	case runes:
+		r := fmt.Sprintf("r%d", n)
+		g.printf("for _, %s := range %s {\n%s.next()\n%s := string(%[1]s)\n", r, iter, loop, val)
+		if idx != "_" {
+			g.printf("%s := %s.index\n", idx, loop)
+		}
+	case counted:
+		i := fmt.Sprintf("i%d", n)
+		g.printf("for %[1]s := 0; %[1]s < %[2]s.length; %[1]s++ {\n%[2]s.next()\n%[3]s := %[1]s\n", i, loop, val)
+		if idx != "_" {
+			g.printf("%s := %s\n", idx, i)
+		}
+	default:
+		g.printf("for %s, %s := range %s {\n%s.next()\n", idx, val, iter, loop)
+	}
+	if idx == "_" {
+		g.printf("_, _ = %s, %s\n", val, loop)
+	} else {
+		g.printf("_, _, _ = %s, %s, %s\n", idx, val, loop)
+	}
+	if err := g.genBlocks(blk.subBlocks); err != nil {
+		return err
+	}
+	g.printf("}\n")
+	g.loops = g.loops[:len(g.loops)-1]
+	if label.used {
+		src := g.buf.Bytes()
+		l := make([]byte, 0, len(src)+len(label.name)+2)
+		l = append(l, src[:pos]...)
+		l = append(l, label.name+":\n"...)
+		l = append(l, src[pos:]...)
+		g.buf.Reset()
+		g.buf.Write(l)
+	}
+	if blk.elseBlock != nil {
+		g.printf("if %s.length == 0 {\n", loop)
+		if err := g.genBlocks(blk.elseBlock.subBlocks); err != nil {
+			return err
+		}
+		g.printf("}\n")
+	}
+	g.printf("}\n")
+	return nil
+}
+
+func (g *goGen) genExpr(e expression) (string, error) {
+	switch expr := e.(type) {
+	case *literalExpr:
+		switch expr.ident.typ {
+		case strIdent:
+			return strconv.Quote(expr.value.(string)), nil
+		case byteIdent:
+			return fmt.Sprintf("byte(%s)", expr.ident.src), nil
+		case durationIdent:
+			return fmt.Sprintf("time.Duration(%d)", expr.value), nil
+		default:
+			return expr.ident.src, nil
+		}
+	case *varExpr:
+		if goName, ok := g.lookup(expr.ident.src); ok {
+			return goName, nil
+		}
+		if _, ok := builtins[expr.ident.src]; ok && !g.envHas(expr.ident.src) {
+			if expr.ident.src != "range" {
+				return "", fmt.Errorf("nbfmt.GenerateGo() error: %s function is not supported (%s)", expr.ident.src, e)
+			}
+			g.usesRange = true
+			return g.rangeFunc, nil
+		}
+		if g.envIsMap {
+			return fmt.Sprintf("env[%s]", strconv.Quote(expr.ident.src)), nil
+		}
+		return "env." + expr.ident.src, nil
+	case *unaryExpr:
+		operand, err := g.genExpr(expr.operand)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s%s)", expr.operator.src, operand), nil
+	case *binaryExpr:
+		if expr.operator == &inOperator {
+			return "", fmt.Errorf("nbfmt.GenerateGo() error: in operator is not supported (%s)", e)
+		}
+		left, err := g.genExpr(expr.left)
+		if err != nil {
+			return "", err
+		}
+		right, err := g.genExpr(expr.right)
+		if err != nil {
+			return "", err
+		}
+		if expr.operator == &rangeOperator {
+			g.usesRange = true
+			return fmt.Sprintf("%sClosed(%s, %s)", g.rangeFunc, left, right), nil
+		}
+		return fmt.Sprintf("(%s %s %s)", left, expr.operator.src, right), nil
+	case *dotExpr:
+		if expr.optional {
+			return "", fmt.Errorf("nbfmt.GenerateGo() error: optional chaining is not supported (%s)", e)
+		}
+		obj, err := g.genExpr(expr.obj)
+		if err != nil {
+			return "", err
+		}
+		return obj + "." + expr.field.src, nil
+	case *indexExpr:
+		if expr.optional {
+			return "", fmt.Errorf("nbfmt.GenerateGo() error: optional chaining is not supported (%s)", e)
+		}
+		obj, err := g.genExpr(expr.obj)
+		if err != nil {
+			return "", err
+		}
+		idx, err := g.genExpr(expr.index)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s[%s]", obj, idx), nil
+	case *callExpr:
+		fn, err := g.genExpr(expr.fn)
+		if err != nil {
+			return "", err
+		}
+		if fn == g.rangeFunc {
+			if err := checkRangeArgs(expr); err != nil {
+				return "", err
+			}
+		}
+		args := make([]string, len(expr.args))
+		for i, arg := range expr.args {
+			a, err := g.genExpr(arg)
+			if err != nil {
+				return "", err
+			}
+			args[i] = a
+		}
+		return fmt.Sprintf("%s(%s)", fn, strings.Join(args, ", ")), nil
+	default:
+		return "", fmt.Errorf("nbfmt.GenerateGo() error: unsupported expression (%s)", e)
+	}
+}
+
+//envHas reports whether name is a field or a method of the env struct, which takes precedence over the builtin
+//function with the same name. The keys of map env are unknown, so the builtin names are not taken as the keys
+func (g *goGen) envHas(name string) bool {
+	if g.envIsMap {
+		return false
+	}
+	obj, _, _ := types.LookupFieldOrMethod(g.envType, true, g.pkg, name)
+	return obj != nil
+}
+
+//checkRangeArgs returns the errors of range(...) which Execute returns, the step is checked here if it is a literal,
+//otherwise the generated range function panics on zero step
+func checkRangeArgs(call *callExpr) error {
+	if len(call.args) < 1 || len(call.args) > 3 {
+		return fmt.Errorf("nbfmt.GenerateGo() error: range takes 1 to 3 arguments (%d supplied) (%s)", len(call.args), call)
+	}
+	if len(call.args) == 3 {
+		if lit, ok := call.args[2].(*literalExpr); ok && lit.value == int64(0) {
+			return fmt.Errorf("nbfmt.GenerateGo() error: step of range cannot be zero (%s)", call)
+		}
+	}
+	return nil
+}
+
+//genValueFunc writes the function which formats values the same way as valueBlock.eval()
+func (g *goGen) genValueFunc() {
+	g.printf(`func %s(w io.Writer, v interface{}) error {
+	var err error
+	switch val := v.(type) {
+	case string:
+		_, err = io.WriteString(w, val)
+	case byte:
+		_, err = fmt.Fprintf(w, "%%c", val)
+	case int, int8, int16, int32, int64, uint, uint16, uint32, uint64:
+		_, err = fmt.Fprintf(w, "%%d", val)
+	case float32, float64:
+		_, err = fmt.Fprintf(w, "%%f", val)
+	case bool:
+		_, err = fmt.Fprintf(w, "%%t", val)
+	case time.Time:
+		_, err = io.WriteString(w, val.Format(%q))
+	case time.Duration:
+		_, err = io.WriteString(w, val.String())
+	case nil:
+		_, err = io.WriteString(w, "nil")
+	default:
+		err = fmt.Errorf("unsupported value type %%T", v)
+	}
+	return err
+}
+`, g.valueFunc, defaultTimeLayout)
+}
+
+//genLoopType writes the type of "loop" variable in for blocks, its fields are updated by next() before each iteration
+func (g *goGen) genLoopType() {
+	g.printf(`
+type %[1]s struct {
+	index, index1, revindex, length int
+	first, last                     bool
+	parent                          *%[1]s
+}
+
+func (l *%[1]s) next() {
+	l.index++
+	l.index1 = l.index + 1
+	l.revindex = l.length - l.index - 1
+	l.first = l.index == 0
+	l.last = l.index == l.length-1
+}
+
+func (l *%[1]s) cycle(vals ...interface{}) interface{} {
+	return vals[l.index%%len(vals)]
+}
+`, g.loopType)
+}
+
+//genRangeFunc writes the functions of range() and .. operator, they return the numbers as slice
+func (g *goGen) genRangeFunc() {
+	g.printf(`
+func %[1]s(args ...int) []int {
+	start, stop, step := 0, 0, 1
+	switch len(args) {
+	case 1:
+		stop = args[0]
+	case 2:
+		start, stop = args[0], args[1]
+	case 3:
+		start, stop, step = args[0], args[1], args[2]
+	}
+	if step == 0 {
+		panic("nbfmt.rangeFunc() error: step of range cannot be zero")
+	}
+	var l []int
+	for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+		l = append(l, i)
+	}
+	return l
+}
+
+func %[1]sClosed(start, stop int) []int {
+	if start <= stop {
+		return %[1]s(start, stop+1)
+	}
+	return %[1]s(start, stop-1, -1)
+}
+`, g.rangeFunc)
+}
@@ -0,0 +1,347 @@
+package nbfmt
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// genPackage writes the Go source of a package into a temporary directory and generates the template there
+func genPackage(t *testing.T, pkgSrc, src string) []byte {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.go"), []byte(pkgSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := GenerateGoPackage(tmpl, dir, "Render", "Data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+const genSrc = `{{ for i, v in Items }}
+{{ if v.Price > 10 && !v.Hidden }}
+{{ i }}: {{ v.Name }}
+{{ else }}
+cheap
+{{ endif }}
+{{ endfor }}
+{{ switch Kind }}
+{{ case "a", "b" }}
+ab
+{{ default }}
+other
+{{ endswitch }}`
+
+func TestGenerateGo(t *testing.T) {
+	code := genPackage(t, `package report
+
+type Item struct {
+	Name   string
+	Price  int
+	Hidden bool
+}
+
+type Data struct {
+	Items []Item
+	Kind  string
+}`, genSrc)
+	for _, want := range []string{
+		"package report",
+		"func Render(w io.Writer, env Data) error {",
+		"iter0 := env.Items\n",
+		"for i, v := range iter0 {",
+		"if (v.Price > 10) && (!v.Hidden) {",
+		"writeRenderValue(w, v.Name)",
+		"switch env.Kind {",
+		`case "a", "b":`,
+		"func writeRenderValue(w io.Writer, v interface{}) error {",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerateGoMapEnv(t *testing.T) {
+	tmpl, err := Parse(`{{ for env, w in list }}{{ env }}{{ w }}{{ endfor }}{{ name }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := GenerateGo(tmpl, "main", "render", "map[string]string")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`iter0 := env["list"]`,
+		"length: utf8.RuneCountInString(iter0)",
+		"for _, r0 := range iter0 {",
+		"w_ := string(r0)\n",
+		"env_ := loop.index\n",
+		`writeRenderValue(w, env["name"])`,
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerateGoInvalidName(t *testing.T) {
+	tmpl, err := Parse(`hello`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateGo(tmpl, "my-pkg", "Render", "Data"); err == nil {
+		t.Error("expected error for invalid package name")
+	}
+	if _, err := GenerateGo(tmpl, "main", "1Render", "Data"); err == nil {
+		t.Error("expected error for invalid function name")
+	}
+}
+
+const genListData = `package main
+
+type Data struct {
+	List []int
+}`
+
+func TestGenerateGoLoopCtrl(t *testing.T) {
+	code := genPackage(t, genListData, `{{ for i, v in List }}{{ switch v }}{{ case 1 }}{{ continue }}{{ endswitch }}{{ for j, c in v }}{{ break }}{{ endfor }}{{ endfor }}`)
+	for _, want := range []string{
+		"loop0:\n",
+		"continue loop0\n",
+		"loop1:\n",
+		"break loop1\n",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, code)
+		}
+	}
+	code = genPackage(t, genListData, `{{ for i, v in List }}{{ v }}{{ endfor }}`)
+	if strings.Contains(string(code), "loop0:") {
+		t.Errorf("generated code contains unused label:\n%s", code)
+	}
+}
+
+func TestGenerateGoRange(t *testing.T) {
+	code := genPackage(t, `package main
+
+type Data struct {
+	N int
+}`, `{{ for v in range(1, N) }}{{ v }}{{ endfor }}{{ for i, v in 1..N }}{{ i }}{{ endfor }}{{ for i, v in N }}{{ v }}{{ endfor }}`)
+	for _, want := range []string{
+		"iter0 := renderRange(1, env.N)",
+		"for _, v := range iter0 {",
+		"iter1 := renderRangeClosed(1, env.N)",
+		"func renderRange(args ...int) []int {",
+		"length2 := int(iter2)\n",
+		"loop := &renderLoop{index: -1, length: length2, parent: nil}\n",
+		"for i2 := 0; i2 < loop.length; i2++ {",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, code)
+		}
+	}
+}
+
+func TestGenerateGoMap(t *testing.T) {
+	const data = `package main
+
+type point struct{ X, Y int }
+
+type Data struct {
+	Prices map[string]float64
+	Points map[point]string
+}`
+	code := genPackage(t, data, `{{ for k, v in Prices }}{{ k }}{{ v }}{{ endfor }}`)
+	for _, want := range []string{
+		"\"sort\"",
+		"keys0 := make([]string, 0, len(iter0))",
+		"return keys0[i] < keys0[j]",
+		"for _, key0 := range keys0 {",
+		"v := iter0[key0]",
+		"k := key0",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, code)
+		}
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.go"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := Parse(`{{ for k, v in Points }}{{ v }}{{ endfor }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateGoPackage(tmpl, dir, "Render", "Data"); err == nil {
+		t.Error("expected error for the map keys which cannot be sorted")
+	}
+}
+
+func TestGenerateGoUnsupportedIteration(t *testing.T) {
+	const data = `package main
+
+type Data struct {
+	Ch    chan int
+	Seq   func(yield func(int) bool)
+	Any   interface{}
+	Items []string
+}`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.go"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{
+		`{{ for v in Ch }}{{ v }}{{ endfor }}`,
+		`{{ for v in Seq }}{{ v }}{{ endfor }}`,
+		`{{ for v in Any }}{{ v }}{{ endfor }}`,
+	} {
+		tmpl, err := Parse(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := GenerateGoPackage(tmpl, dir, "Render", "Data"); err == nil || !strings.Contains(err.Error(), "cannot iterate") {
+			t.Errorf("%s: expected error for unsupported iteration, got %v", src, err)
+		}
+	}
+	tmpl, err := Parse(`{{ for v in Items }}{{ v }}{{ endfor }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateGoPackage(tmpl, dir, "Render", "Data"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGenerateGoUnsupportedExpr(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		want string
+	}{
+		{`{{ if "a" in list }}yes{{ endif }}`, "in operator is not supported"},
+		{`{{ "a" in list }}`, "in operator is not supported"},
+		{`{{ user?.name }}`, "optional chaining is not supported"},
+		{`{{ number(total) }}`, "number function is not supported"},
+		{`{{ now() }}`, "now function is not supported"},
+		{`{{ for v in sort(list) }}{{ v }}{{ endfor }}`, "sort function is not supported"},
+		{`{{ date(when, "2006") }}`, "date function is not supported"},
+		{`{{ for i in range(1, 5, 0) }}{{ i }}{{ endfor }}`, "step of range cannot be zero"},
+		{`{{ for i in range() }}{{ i }}{{ endfor }}`, "range takes 1 to 3 arguments"},
+		{`{{ for i in range(1, 2, 3, 4) }}{{ i }}{{ endfor }}`, "range takes 1 to 3 arguments"},
+	} {
+		tmpl, err := Parse(c.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = GenerateGo(tmpl, "main", "render", "map[string]interface{}")
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: expected error %q, got %v", c.src, c.want, err)
+		}
+	}
+}
+
+// genRunData is the env type of TestGenerateGoRun, genRunEnv is the same type for Execute
+const genRunData = `package main
+
+type Data struct {
+	Items  []string
+	Prices map[string]int
+	Name   string
+	N      int
+	Empty  []int
+}
+`
+
+type genRunEnv struct {
+	Items  []string
+	Prices map[string]int
+	Name   string
+	N      int
+	Empty  []int
+}
+
+const genRunMain = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	env := Data{
+		Items:  []string{"a", "b", "c"},
+		Prices: map[string]int{"pear": 3, "apple": 1, "fig": 2, "kiwi": 5},
+		Name:   "héllo",
+		N:      3,
+	}
+	if err := Render(os.Stdout, env); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`
+
+// TestGenerateGoRun builds and runs the generated code, its output must be the same as Execute
+func TestGenerateGoRun(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	src := `{{ for i, v in Items }}{{ i }}:{{ v }}{{ if loop.last }}.{{ else }},{{ endif }}{{ endfor }}
+{{ for k, v in Prices }}{{ loop.index1 }}/{{ loop.length }} {{ k }}={{ v }} {{ endfor }}
+{{ for i, c in Name }}{{ i }}{{ c }}{{ if loop.last }}/{{ loop.length }}{{ endif }}{{ endfor }}
+{{ for i in N }}{{ i }}({{ for v in Items }}{{ if !loop.first }} {{ endif }}{{ loop.parent.index }}{{ v }}{{ endfor }}){{ endfor }}
+{{ for v in Empty }}{{ v }}{{ else }}empty{{ endfor }}
+{{ for i in range(N) }}{{ i }}{{ endfor }}{{ for i in range(5, 0, -2) }}{{ i }}{{ endfor }}{{ for i in 3..1 }}{{ i }}{{ endfor }}`
+	tmpl, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := tmpl.Execute(genRunEnv{
+		Items:  []string{"a", "b", "c"},
+		Prices: map[string]int{"pear": 3, "apple": 1, "fig": 2, "kiwi": 5},
+		Name:   "héllo",
+		N:      3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module genrun\n\ngo 1.16\n",
+		"data.go": genRunData,
+		"main.go": genRunMain,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code, err := GenerateGoPackage(tmpl, dir, "Render", "Data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "render_gen.go"), code, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(goBin, "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("go run error: %v\n%s\n%s", err, ee.Stderr, code)
+		}
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("generated code output:\n%s\nExecute output:\n%s", out, want)
+	}
+}
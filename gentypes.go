@@ -0,0 +1,92 @@
+package nbfmt
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+)
+
+//generatedHeader is the first line of the files generated by GenerateGo
+const generatedHeader = "// Code generated by nbfmt. DO NOT EDIT."
+
+//loadPackage type-checks the Go package in dir from source, the files generated by nbfmt are skipped because
+//they may be outdated. The type errors are ignored, e.g. the package may call the function which is not generated yet
+func loadPackage(fset *token.FileSet, dir string) (*types.Package, error) {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, fmt.Errorf("nbfmt.loadPackage() error: %v", err)
+	}
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		path := filepath.Join(dir, name)
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("nbfmt.loadPackage() error: %v", err)
+		}
+		if bytes.HasPrefix(src, []byte(generatedHeader)) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, src, 0)
+		if err != nil {
+			return nil, fmt.Errorf("nbfmt.loadPackage() error: %v", err)
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("nbfmt.loadPackage() error: no Go files except the generated ones in %s", dir)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil), Error: func(error) {}}
+	pkg, _ := conf.Check(bp.ImportPath, fset, files, nil)
+	return pkg, nil
+}
+
+//typeOf type-checks the Go expression x of the generated function, in which env, the range functions and
+//the variables of the enclosing for blocks are declared
+func (g *goGen) typeOf(x string) (types.Type, error) {
+	e, err := parser.ParseExpr(x)
+	if err != nil {
+		return nil, err
+	}
+	// the scope is placed after all the files of fset, so CheckExpr finds it by the position
+	g.pos += 2
+	pos := token.Pos(g.fset.Base() + g.pos)
+	sc := types.NewScope(g.pkg.Scope(), pos, pos+1, "")
+	sc.Insert(types.NewVar(token.NoPos, g.pkg, "env", g.envType))
+	ints := types.NewSlice(types.Typ[types.Int])
+	for _, name := range []string{g.rangeFunc, g.rangeFunc + "Closed"} {
+		params := types.NewTuple(types.NewVar(token.NoPos, g.pkg, "args", ints))
+		results := types.NewTuple(types.NewVar(token.NoPos, g.pkg, "", ints))
+		sc.Insert(types.NewFunc(token.NoPos, g.pkg, name, types.NewSignature(nil, params, results, true)))
+	}
+	// the inner variables shadow the outer ones, Insert keeps the first one
+	for i := len(g.varTypes) - 1; i >= 0; i-- {
+		for name, typ := range g.varTypes[i] {
+			if typ != nil {
+				sc.Insert(types.NewVar(token.NoPos, g.pkg, name, typ))
+			}
+		}
+	}
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	if err := types.CheckExpr(g.fset, g.pkg, pos, e, info); err != nil {
+		return nil, err
+	}
+	return info.TypeOf(e), nil
+}
+
+//typeString is the name of typ in the generated file, the packages of the names are imported
+func (g *goGen) typeString(typ types.Type) string {
+	return types.TypeString(typ, func(p *types.Package) string {
+		if p == g.pkg {
+			return ""
+		}
+		g.imports[p.Path()] = true
+		return p.Name()
+	})
+}
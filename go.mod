@@ -1 +1,3 @@
 module github.com/wangjun861205/nbfmt
+
+go 1.16
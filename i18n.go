@@ -0,0 +1,438 @@
+package nbfmt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Catalog holds the translated messages of several locales for the t statement, a message can have plural forms
+//which are chosen by the CLDR plural category of the count. It is safe to load messages while templates are executed
+type Catalog struct {
+	mu sync.RWMutex
+	//messages maps the normalized locale to the messages of the locale by key
+	messages map[string]map[string]*message
+}
+
+//message is a translated message, forms maps the plural categories to the texts if the message has plural forms
+type message struct {
+	text  string
+	forms map[string]string
+}
+
+//NewCatalog creates an empty Catalog
+func NewCatalog() *Catalog {
+	return &Catalog{messages: make(map[string]map[string]*message)}
+}
+
+//Add adds the message of key in locale, the message can refer to the arguments of t statement by {0}, {1}...
+func (c *Catalog) Add(locale, key, text string) {
+	c.add(locale, key, &message{text: text})
+}
+
+//AddPlural adds the message of key in locale with the texts of the plural categories (zero, one, two, few, many and other),
+//the first argument of t statement is the count which chooses the text, other is used if the category is missing
+func (c *Catalog) AddPlural(locale, key string, forms map[string]string) {
+	m := &message{forms: make(map[string]string, len(forms))}
+	for cat, text := range forms {
+		m.forms[cat] = text
+	}
+	c.add(locale, key, m)
+}
+
+func (c *Catalog) add(locale, key string, m *message) {
+	locale = normalizeLocale(locale)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.messages[locale] == nil {
+		c.messages[locale] = make(map[string]*message)
+	}
+	c.messages[locale][key] = m
+}
+
+//LoadJSON loads the messages of locale from a JSON object. A string value is a message, an object whose keys are
+//all plural categories is a message with plural forms, and other objects are namespaces whose keys are joined by dots:
+//	{"title": "Cart", "items": {"one": "{0} item", "other": "{0} items"}, "errors": {"empty": "Nothing here"}}
+func (c *Catalog) LoadJSON(locale string, r io.Reader) error {
+	var obj map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&obj); err != nil {
+		return fmt.Errorf("nbfmt.Catalog.LoadJSON() error: %v", err)
+	}
+	return c.loadJSON(locale, "", obj)
+}
+
+func (c *Catalog) loadJSON(locale, prefix string, obj map[string]interface{}) error {
+	for k, v := range obj {
+		key := prefix + k
+		switch val := v.(type) {
+		case string:
+			c.Add(locale, key, val)
+		case map[string]interface{}:
+			forms, ok := pluralForms(val)
+			if !ok {
+				if err := c.loadJSON(locale, key+".", val); err != nil {
+					return err
+				}
+				continue
+			}
+			c.AddPlural(locale, key, forms)
+		default:
+			return fmt.Errorf("nbfmt.Catalog.LoadJSON() error: the message of %s is not a string or an object (%T)", key, v)
+		}
+	}
+	return nil
+}
+
+//pluralForms returns the forms if all the keys of obj are plural categories and all the values are strings
+func pluralForms(obj map[string]interface{}) (map[string]string, bool) {
+	if len(obj) == 0 {
+		return nil, false
+	}
+	forms := make(map[string]string, len(obj))
+	for k, v := range obj {
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+		switch k {
+		case "zero", "one", "two", "few", "many", "other":
+			forms[k] = s
+		default:
+			return nil, false
+		}
+	}
+	return forms, true
+}
+
+//LoadPO loads the messages of locale from a gettext .po file, msgid is the key. The plural forms msgstr[0], msgstr[1]...
+//are the plural categories of the language in the order of gettext (e.g. one, few, many for Russian).
+//The untranslated messages and the messages with msgctxt are skipped
+func (c *Catalog) LoadPO(locale string, r io.Reader) error {
+	type entry struct {
+		ctx    bool
+		id     string
+		plural bool
+		strs   map[int]string
+	}
+	var cur *entry
+	// field is the keyword which the continued strings belong to, index is the index of msgstr[n]
+	var field string
+	var index int
+	categories := pluralRuleOf(locale).poForms
+	flush := func() {
+		defer func() { cur, field = nil, "" }()
+		if cur == nil || cur.ctx || cur.id == "" {
+			return
+		}
+		if !cur.plural {
+			if s := cur.strs[0]; s != "" {
+				c.Add(locale, cur.id, s)
+			}
+			return
+		}
+		forms := make(map[string]string)
+		for i, s := range cur.strs {
+			if s != "" && i < len(categories) {
+				forms[categories[i]] = s
+			}
+		}
+		if len(forms) > 0 {
+			c.AddPlural(locale, cur.id, forms)
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			flush()
+			continue
+		}
+		if line[0] == '#' {
+			continue
+		}
+		keyword, rest := "", line
+		if line[0] != '"' {
+			i := strings.IndexByte(line, ' ')
+			if i < 0 {
+				return fmt.Errorf("nbfmt.Catalog.LoadPO() error: invalid line %d (%s)", lineNo, line)
+			}
+			keyword, rest = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		s, err := strconv.Unquote(rest)
+		if err != nil {
+			return fmt.Errorf("nbfmt.Catalog.LoadPO() error: invalid string at line %d (%s)", lineNo, line)
+		}
+		if keyword != "msgctxt" && keyword != "msgid" && keyword != "" && cur == nil {
+			return fmt.Errorf("nbfmt.Catalog.LoadPO() error: %s without msgid at line %d", keyword, lineNo)
+		}
+		switch {
+		case keyword == "":
+			switch field {
+			case "msgid":
+				cur.id += s
+			case "msgstr":
+				cur.strs[index] += s
+			case "":
+				return fmt.Errorf("nbfmt.Catalog.LoadPO() error: unexpected string at line %d (%s)", lineNo, line)
+			}
+			continue
+		case keyword == "msgctxt":
+			flush()
+			cur = &entry{ctx: true, strs: make(map[int]string)}
+		case keyword == "msgid":
+			// msgid starts a new entry unless it follows msgctxt
+			if cur == nil || field != "msgctxt" {
+				flush()
+				cur = &entry{strs: make(map[int]string)}
+			}
+			cur.id = s
+		case keyword == "msgid_plural":
+			cur.plural = true
+		case keyword == "msgstr":
+			index = 0
+			cur.strs[index] = s
+		case strings.HasPrefix(keyword, "msgstr[") && strings.HasSuffix(keyword, "]"):
+			index, err = strconv.Atoi(keyword[len("msgstr[") : len(keyword)-1])
+			if err != nil {
+				return fmt.Errorf("nbfmt.Catalog.LoadPO() error: invalid keyword at line %d (%s)", lineNo, keyword)
+			}
+			cur.strs[index] = s
+			keyword = "msgstr"
+		default:
+			return fmt.Errorf("nbfmt.Catalog.LoadPO() error: invalid keyword at line %d (%s)", lineNo, keyword)
+		}
+		field = keyword
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("nbfmt.Catalog.LoadPO() error: %v", err)
+	}
+	flush()
+	return nil
+}
+
+//normalizeLocale converts a locale to lower case with hyphens, e.g. pt_BR to pt-br
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+//localeChain returns the locales which are searched for a message, each locale is followed by its parents,
+//e.g. zh-Hant-TW, en is searched as zh-hant-tw, zh-hant, zh, en
+func localeChain(locales []string) []string {
+	var chain []string
+	seen := make(map[string]bool)
+	for _, l := range locales {
+		l = normalizeLocale(l)
+		for l != "" {
+			if !seen[l] {
+				seen[l] = true
+				chain = append(chain, l)
+			}
+			i := strings.LastIndexByte(l, '-')
+			if i < 0 {
+				break
+			}
+			l = l[:i]
+		}
+	}
+	return chain
+}
+
+//lookup finds the message of key in the first locale of the chain which has it
+func (c *Catalog) lookup(chain []string, key string) (*message, string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, l := range chain {
+		if m, ok := c.messages[l][key]; ok {
+			return m, l, true
+		}
+	}
+	return nil, "", false
+}
+
+//translate returns the message of key for the locales of opts with the arguments filled in, the key itself
+//is the message if it is not found, so the messages of the source language can be used as keys
+func translate(opts *options, key string, args []interface{}) (string, error) {
+	var chain []string
+	if opts != nil {
+		chain = localeChain(opts.locales)
+	}
+	text, locale := key, ""
+	if opts != nil && opts.catalog != nil {
+		if m, l, ok := opts.catalog.lookup(chain, key); ok {
+			locale = l
+			text = m.text
+			if m.forms != nil {
+				if len(args) == 0 {
+					return "", fmt.Errorf("nbfmt.translate() error: %s has plural forms but no count is given", key)
+				}
+				cv := reflect.ValueOf(args[0])
+				if !isNumberValue(cv) {
+					return "", fmt.Errorf("nbfmt.translate() error: the count of %s is not a number (%v)", key, args[0])
+				}
+				rule := pluralRuleOf(locale)
+				cat := rule.rule(toFloat(cv))
+				form, ok := m.forms[cat]
+				if !ok {
+					form, ok = m.forms["other"]
+				}
+				// the .po files of some languages have no other form (e.g. Russian), then the last form is used
+				for i := len(rule.poForms) - 1; !ok && i >= 0; i-- {
+					form, ok = m.forms[rule.poForms[i]]
+				}
+				if !ok {
+					return "", fmt.Errorf("nbfmt.translate() error: %s has no %s or other form in %s", key, cat, locale)
+				}
+				text = form
+			}
+		}
+	}
+	return fillArgs(text, args, numberFormatOf(opts))
+}
+
+//fillArgs replaces {0}, {1}... in text with the arguments, the numbers are written in the number format nf,
+//the other braces are kept as they are
+func fillArgs(text string, args []interface{}, nf *numberFormat) (string, error) {
+	if !strings.Contains(text, "{") {
+		return text, nil
+	}
+	builder := strings.Builder{}
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' {
+			builder.WriteByte(text[i])
+			continue
+		}
+		end := strings.IndexByte(text[i:], '}')
+		if end < 0 {
+			builder.WriteString(text[i:])
+			break
+		}
+		n, err := strconv.Atoi(text[i+1 : i+end])
+		if err != nil {
+			builder.WriteByte(text[i])
+			continue
+		}
+		if n < 0 || n >= len(args) {
+			return "", fmt.Errorf("nbfmt.fillArgs() error: argument {%d} is out of range (%d arguments) in message (%s)", n, len(args), text)
+		}
+		s, ok := formatValue(args[n])
+		if v := reflect.ValueOf(args[n]); isNumberValue(v) && v.Kind() != reflect.Uint8 {
+			s = nf.format(v, -1)
+		}
+		if !ok {
+			return "", fmt.Errorf("nbfmt.fillArgs() error: unsupported argument type (%T) in message (%s)", args[n], text)
+		}
+		builder.WriteString(s)
+		i += end
+	}
+	return builder.String(), nil
+}
+
+//pluralRule is the CLDR plural rule of a language, poForms are the categories of msgstr[0], msgstr[1]... in .po files
+type pluralRule struct {
+	rule    func(n float64) string
+	poForms []string
+}
+
+var (
+	otherRule = pluralRule{func(n float64) string { return "other" }, []string{"other"}}
+	oneRule   = pluralRule{func(n float64) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}, []string{"one", "other"}}
+	// 0 and 1 are singular in French and Portuguese
+	frenchRule = pluralRule{func(n float64) string {
+		if n >= 0 && n < 2 {
+			return "one"
+		}
+		return "other"
+	}, []string{"one", "other"}}
+	russianRule = pluralRule{func(n float64) string {
+		if n != math.Trunc(n) {
+			return "other"
+		}
+		i := int64(math.Abs(n))
+		switch {
+		case i%10 == 1 && i%100 != 11:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	}, []string{"one", "few", "many"}}
+	polishRule = pluralRule{func(n float64) string {
+		if n != math.Trunc(n) {
+			return "other"
+		}
+		i := int64(math.Abs(n))
+		switch {
+		case i == 1:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	}, []string{"one", "few", "many"}}
+	czechRule = pluralRule{func(n float64) string {
+		switch {
+		case n != math.Trunc(n):
+			return "many"
+		case n == 1:
+			return "one"
+		case n >= 2 && n <= 4:
+			return "few"
+		default:
+			return "other"
+		}
+	}, []string{"one", "few", "other"}}
+	arabicRule = pluralRule{func(n float64) string {
+		if n != math.Trunc(n) {
+			return "other"
+		}
+		i := int64(math.Abs(n))
+		switch {
+		case i == 0:
+			return "zero"
+		case i == 1:
+			return "one"
+		case i == 2:
+			return "two"
+		case i%100 >= 3 && i%100 <= 10:
+			return "few"
+		case i%100 >= 11:
+			return "many"
+		default:
+			return "other"
+		}
+	}, []string{"zero", "one", "two", "few", "many", "other"}}
+)
+
+//pluralRules are the plural rules by language, the languages which are not listed use the rule of English
+var pluralRules = map[string]pluralRule{
+	"ja": otherRule, "zh": otherRule, "ko": otherRule, "th": otherRule, "vi": otherRule, "id": otherRule, "ms": otherRule,
+	"fr": frenchRule, "pt": frenchRule,
+	"ru": russianRule, "uk": russianRule, "be": russianRule,
+	"pl": polishRule,
+	"cs": czechRule, "sk": czechRule,
+	"ar": arabicRule,
+}
+
+func pluralRuleOf(locale string) pluralRule {
+	lang := normalizeLocale(locale)
+	if i := strings.IndexByte(lang, '-'); i >= 0 {
+		lang = lang[:i]
+	}
+	if r, ok := pluralRules[lang]; ok {
+		return r
+	}
+	return oneRule
+}
@@ -0,0 +1,117 @@
+package nbfmt
+
+import (
+	"strings"
+	"testing"
+)
+
+const catalogJSON = `{
+	"greeting": "Hello, {0}!",
+	"cart": {
+		"title": "Your cart",
+		"items": {"one": "{0} item", "other": "{0} items"}
+	}
+}`
+
+const catalogPO = `# Russian
+msgid ""
+msgstr ""
+"Plural-Forms: nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);\n"
+
+msgid "greeting"
+msgstr "Привет, {0}!"
+
+msgid "cart.items"
+msgid_plural "{0} items"
+msgstr[0] "{0} товар"
+msgstr[1] "{0} товара"
+msgstr[2] "{0} "
+"товаров"
+
+msgctxt "menu"
+msgid "cart.title"
+msgstr "Меню"
+
+msgid "untranslated"
+msgstr ""
+`
+
+func TestTranslation(t *testing.T) {
+	catalog := NewCatalog()
+	if err := catalog.LoadJSON("en", strings.NewReader(catalogJSON)); err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.LoadPO("ru", strings.NewReader(catalogPO)); err != nil {
+		t.Fatal(err)
+	}
+	catalog.Add("pt", "greeting", "Olá, {0}!")
+	catalog.AddPlural("pt-BR", "cart.items", map[string]string{"one": "{0} item", "other": "{0} itens"})
+	catalog.AddPlural("ar", "cart.items", map[string]string{"zero": "no items", "two": "two items", "other": "{0}"})
+	catalog.Add("en", "two", "{0}|{1}")
+	env := map[string]interface{}{"name": "Ann", "n": 3, "t": "value of t", "a": 5, "b": 2, "l": []string{"x", "y"}, "f": strings.ToUpper}
+	tests := []struct {
+		src     string
+		locales []string
+		want    string
+	}{
+		{`{{ t "greeting" name }}`, []string{"en"}, "Hello, Ann!"},
+		{`{{ t "cart.title" }}: {{ t "cart.items" n }}`, []string{"en-US"}, "Your cart: 3 items"},
+		{`{{ t "cart.items" 1 }}`, []string{"en"}, "1 item"},
+		{`{{ t "greeting" name }}`, []string{"ru"}, "Привет, Ann!"},
+		{`{{ for i in [1, 2, 5, 11, 21, 22, 1.5] }}{{ t "cart.items" i }};{{ endfor }}`, []string{"ru"}, "1 товар;2 товара;5 товаров;11 товаров;21 товар;22 товара;1,5 товаров;"},
+		{`{{ t "cart.title" }}`, []string{"ru", "en"}, "Your cart"},
+		{`{{ t "untranslated" }}`, []string{"ru"}, "untranslated"},
+		{`{{ t "greeting" name }} {{ t "cart.items" 0 }} {{ t "cart.items" 2 }}`, []string{"pt_BR", "en"}, "Olá, Ann! 0 item 2 itens"},
+		{`{{ t "cart.items" 0 }}, {{ t "cart.items" 2 }}, {{ t "cart.items" 7 }}`, []string{"ar"}, "no items, two items, 7"},
+		{`{{ t "Welcome back, {0}. {missing}" name }}`, []string{"fr"}, "Welcome back, Ann. {missing}"},
+		{`{{ t }} {{ t + "!" }}`, []string{"en"}, "value of t value of t!"},
+		{`{{ if n > 1 }}{{ t "cart.items" (n + 1) }}{{ endif }}`, nil, "4 items"},
+		{`{{ t "two" a -1 }}`, nil, "5|-1"},
+		{`{{ t "two" l[0] [1][0] }}`, nil, "x|1"},
+		{`{{ t "two" a (b) }}`, nil, "5|2"},
+		{`{{ t "two" l[1] f(name) }}`, nil, "y|ANN"},
+		{`{{ t "two" f(l[0] + name) {"k": [1, 2]}["k"][1] }}`, nil, "XANN|2"},
+		{`{{ t "two" "a b" 'c' }}`, nil, "a b|c"},
+		{`{{ t "cart.items" 1234 }}`, []string{"de", "en"}, "1.234 items"},
+	}
+	for _, test := range tests {
+		temp, err := Parse(test.src, Translations(catalog), Locale("en"))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		var opts []Option
+		if test.locales != nil {
+			opts = append(opts, Locale(test.locales...))
+		}
+		got, err := temp.Execute(env, opts...)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`{{ t "cart.items" }}`,
+		`{{ t "cart.items" name }}`,
+		`{{ t "greeting" }}`,
+		`{{ t n }}`,
+		`{{ t "cart.items" n + 1 }}`,
+		`{{ t "two" a-1 b }}`,
+		`{{ t "two" a b) }}`,
+		`{{ name 1 }}`,
+		`{{ name "x" }}`,
+	} {
+		if got, err := Fmt(src, env, Translations(catalog), Locale("en")); err == nil {
+			t.Errorf("%s: expected error, got %q", src, got)
+		}
+	}
+	if err := NewCatalog().LoadPO("en", strings.NewReader(`msgstr "x"`)); err == nil {
+		t.Errorf("expected error for msgstr without msgid")
+	}
+	if err := NewCatalog().LoadJSON("en", strings.NewReader(`{"a": 1}`)); err == nil {
+		t.Errorf("expected error for a message which is not a string")
+	}
+}
@@ -0,0 +1,185 @@
+package nbfmt
+
+import (
+	"reflect"
+	"sort"
+	"unicode/utf8"
+)
+
+//iterLen returns the number of elements of obj, ok is false if obj cannot be iterated by for block.
+//nil is iterable and it has no element, length is -1 if the number of elements is unknown before iterating
+//(channel, iterator function and Iterable)
+func iterLen(obj interface{}) (length int64, ok bool) {
+	switch v := obj.(type) {
+	case intRange:
+		return v.len(), true
+	case Iterable:
+		return -1, true
+	}
+	val := reflect.ValueOf(obj)
+	switch val.Kind() {
+	case reflect.Invalid:
+		return 0, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return int64(val.Len()), true
+	case reflect.String:
+		return int64(utf8.RuneCountInString(val.String())), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val.Int() < 0 {
+			return 0, true
+		}
+		return val.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(val.Uint()), true
+	case reflect.Chan:
+		if val.Type().ChanDir()&reflect.RecvDir == 0 {
+			return 0, false
+		}
+		return -1, true
+	case reflect.Func:
+		if !isSeqFunc(val.Type()) {
+			return 0, false
+		}
+		return -1, true
+	default:
+		return 0, false
+	}
+}
+
+//isSeqFunc reports whether typ is an iterator function like iter.Seq (func(yield func(V) bool))
+//or iter.Seq2 (func(yield func(K, V) bool))
+func isSeqFunc(typ reflect.Type) bool {
+	if typ.NumIn() != 1 || typ.NumOut() != 0 {
+		return false
+	}
+	yield := typ.In(0)
+	return yield.Kind() == reflect.Func && (yield.NumIn() == 1 || yield.NumIn() == 2) &&
+		yield.NumOut() == 1 && yield.Out(0).Kind() == reflect.Bool
+}
+
+//isKeyed reports whether the keys of obj are part of its elements (map keys, keys of iter.Seq2 and Iterable)
+//rather than the positions of the elements
+func isKeyed(obj interface{}) bool {
+	switch obj.(type) {
+	case MapSlice, Iterable:
+		return true
+	}
+	val := reflect.ValueOf(obj)
+	switch val.Kind() {
+	case reflect.Map:
+		return true
+	case reflect.Func:
+		return isSeqFunc(val.Type()) && val.Type().In(0).NumIn() == 2
+	default:
+		return false
+	}
+}
+
+//each calls fn with the index (key of map) and the value of every element of obj until fn returns false.
+//An integer n is iterated as 0 to n-1, a string is iterated by runes, a channel is received until it is closed,
+//an iterator function yields the elements of iter.Seq with the indexes and the key value pairs of iter.Seq2
+func each(obj interface{}, fn func(key, val interface{}) bool) error {
+	switch v := obj.(type) {
+	case MapSlice:
+		for _, item := range v {
+			if !fn(item.Key, item.Value) {
+				return nil
+			}
+		}
+		return nil
+	case intRange:
+		for i := int64(0); i < v.len(); i++ {
+			if !fn(i, v.at(i)) {
+				return nil
+			}
+		}
+		return nil
+	case Iterable:
+		return v.Iterate(fn)
+	}
+	val := reflect.ValueOf(obj)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if !fn(int64(i), val.Index(i).Interface()) {
+				return nil
+			}
+		}
+	case reflect.Map:
+		for _, key := range val.MapKeys() {
+			if !fn(key.Interface(), val.MapIndex(key).Interface()) {
+				return nil
+			}
+		}
+	case reflect.String:
+		var i int64
+		for _, r := range val.String() {
+			if !fn(i, string(r)) {
+				return nil
+			}
+			i++
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		length, _ := iterLen(obj)
+		for i := int64(0); i < length; i++ {
+			if !fn(i, i) {
+				return nil
+			}
+		}
+	case reflect.Chan:
+		for i := int64(0); ; i++ {
+			v, ok := val.Recv()
+			if !ok || !fn(i, v.Interface()) {
+				return nil
+			}
+		}
+	case reflect.Func:
+		var i int64
+		yield := reflect.MakeFunc(val.Type().In(0), func(args []reflect.Value) []reflect.Value {
+			var cont bool
+			if len(args) == 1 {
+				cont = fn(i, args[0].Interface())
+				i++
+			} else {
+				cont = fn(args[0].Interface(), args[1].Interface())
+			}
+			return []reflect.Value{reflect.ValueOf(cont)}
+		})
+		val.Call([]reflect.Value{yield})
+	}
+	return nil
+}
+
+//sortMap converts map or MapSlice into a MapSlice ordered by keys (or by values if byValue is true),
+//MapSlice keeps its order if neither desc nor byValue is set. ok is false if obj is not a map or a MapSlice
+func sortMap(obj interface{}, desc, byValue bool) (sorted MapSlice, ok bool) {
+	var items MapSlice
+	if m, isMapSlice := obj.(MapSlice); isMapSlice {
+		if !desc && !byValue {
+			return m, true
+		}
+		items = append(items, m...)
+	} else {
+		val := reflect.ValueOf(obj)
+		if val.Kind() != reflect.Map {
+			return nil, false
+		}
+		items = make(MapSlice, 0, val.Len())
+		for _, key := range val.MapKeys() {
+			items = append(items, MapItem{key.Interface(), val.MapIndex(key).Interface()})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		c := compareValues(items[i].Key, items[j].Key)
+		if byValue {
+			if vc := compareValues(items[i].Value, items[j].Value); vc != 0 {
+				c = vc
+			}
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	return items, true
+}
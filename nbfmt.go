@@ -250,33 +250,67 @@ package nbfmt
 // 	return index, nil
 // }
 
-// //Fmt format template by value
-// func Fmt(temp string, value interface{}) (string, error) {
-// 	l := fmtRe.FindAllStringSubmatch(temp, -1)
-// 	for _, t := range l {
-// 		obj, err := find(t[1], value)
-// 		if err != nil {
-// 			return "", err
-// 		}
-// 		s, err := convert(obj)
-// 		if err != nil {
-// 			return "", err
-// 		}
-// 		temp = strings.Replace(temp, t[0], s, -1)
-// 	}
-// 	return temp, nil
-// }
+//Comparable is implemented by the types which have their own order, it is used by the comparison operators
+//and to sort the keys of map in for block. Compare returns a negative number, zero or a positive number when the value is less than, equal to or greater than other.
+//Compare is only called when other has the same type as the value, the values of different types are not equal and cannot be ordered
+type Comparable interface {
+	Compare(other interface{}) int
+}
+
+//MapItem is a key value pair of MapSlice
+type MapItem struct {
+	Key   interface{}
+	Value interface{}
+}
+
+//MapSlice is a map which keeps the insertion order, for block iterates it in order as a map
+type MapSlice []MapItem
+
+//Iterable is implemented by the types which can be iterated by for block, Iterate calls yield with the index (or key)
+//and the value of every element in order, it must stop when yield returns false. The elements are rendered
+//while they are yielded, so large result sets can be streamed into a template
+type Iterable interface {
+	Iterate(yield func(key, value interface{}) bool) error
+}
 
-func Fmt(src string, env map[string]interface{}) (string, error) {
+//Parse parses src into a Template, opts are used when the template is executed
+func Parse(src string, opts ...Option) (*Template, error) {
 	sl, err := parseStmt(src)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	temp, err := genTemplate(sl)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(&temp.opts)
+	}
+	return temp, nil
+}
+
+//Execute renders the template by env, env can be a map with string keys, a struct or a pointer to struct,
+//the names in the template are the keys of map or the fields (and methods) of struct. opts are applied after
+//the options of Parse for this execution only
+func (t *Template) Execute(env interface{}, opts ...Option) (string, error) {
+	o := t.opts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	sc, err := newRootScope(env, &o)
+	if err != nil {
+		return "", err
+	}
+	return t.eval(sc)
+}
+
+//Fmt parses src and renders it by env, env is the same as Template.Execute
+func Fmt(src string, env interface{}, opts ...Option) (string, error) {
+	temp, err := Parse(src, opts...)
 	if err != nil {
 		return "", err
 	}
-	s, err := temp.eval(env)
+	s, err := temp.Execute(env)
 	if err != nil {
 		return "", err
 	}
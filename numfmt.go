@@ -0,0 +1,267 @@
+package nbfmt
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//numberFormat is how the numbers are written in a locale
+type numberFormat struct {
+	group   string
+	decimal string
+	//groupSize2 is the size of the groups before the last one, 0 means 3 (it is 2 in India, e.g. 12,34,567)
+	groupSize2 int
+	//minGrouping is the least number of digits before the last group to use the group separator (e.g. 1234 in Spanish)
+	minGrouping int
+	//currencyAfter puts the currency symbol after the number, currencySpace separates them by a no-break space
+	currencyAfter bool
+	currencySpace bool
+	//percentSpace separates the number and the percent sign by a no-break space
+	percentSpace bool
+	compact      []compactUnit
+}
+
+//compactUnit is the suffix of the numbers not less than value in compact format, e.g. K for 1000
+type compactUnit struct {
+	value  float64
+	suffix string
+}
+
+//nbsp is the no-break space which separates the numbers and the symbols in some locales
+const nbsp = "\u00a0"
+
+//numberFormats are the number formats by locale, the locales which are not listed use the format of their parents or English
+var numberFormats = map[string]*numberFormat{
+	"en": {group: ",", decimal: ".", compact: []compactUnit{{1e3, "K"}, {1e6, "M"}, {1e9, "B"}, {1e12, "T"}}},
+	"en-in": {group: ",", decimal: ".", groupSize2: 2,
+		compact: []compactUnit{{1e3, "K"}, {1e5, "L"}, {1e7, "Cr"}}},
+	"de": {group: ".", decimal: ",", currencyAfter: true, currencySpace: true, percentSpace: true,
+		compact: []compactUnit{{1e3, nbsp + "Tsd."}, {1e6, nbsp + "Mio."}, {1e9, nbsp + "Mrd."}, {1e12, nbsp + "Bio."}}},
+	"de-ch": {group: "’", decimal: ".", currencySpace: true,
+		compact: []compactUnit{{1e3, nbsp + "Tsd."}, {1e6, nbsp + "Mio."}, {1e9, nbsp + "Mrd."}, {1e12, nbsp + "Bio."}}},
+	"fr": {group: "\u202f", decimal: ",", currencyAfter: true, currencySpace: true, percentSpace: true,
+		compact: []compactUnit{{1e3, nbsp + "k"}, {1e6, nbsp + "M"}, {1e9, nbsp + "Md"}, {1e12, nbsp + "Bn"}}},
+	"es": {group: ".", decimal: ",", minGrouping: 2, currencyAfter: true, currencySpace: true, percentSpace: true,
+		compact: []compactUnit{{1e3, nbsp + "mil"}, {1e6, nbsp + "M"}, {1e9, nbsp + "mil" + nbsp + "M"}, {1e12, nbsp + "B"}}},
+	"it": {group: ".", decimal: ",", currencyAfter: true, currencySpace: true,
+		compact: []compactUnit{{1e6, nbsp + "Mln"}, {1e9, nbsp + "Mrd"}, {1e12, nbsp + "Bln"}}},
+	"nl": {group: ".", decimal: ",", currencySpace: true,
+		compact: []compactUnit{{1e3, "K"}, {1e6, nbsp + "mln."}, {1e9, nbsp + "mld."}, {1e12, nbsp + "bln."}}},
+	"pt": {group: ".", decimal: ",", currencySpace: true,
+		compact: []compactUnit{{1e3, nbsp + "mil"}, {1e6, nbsp + "mi"}, {1e9, nbsp + "bi"}, {1e12, nbsp + "tri"}}},
+	"ru": {group: nbsp, decimal: ",", currencyAfter: true, currencySpace: true, percentSpace: true,
+		compact: []compactUnit{{1e3, nbsp + "тыс."}, {1e6, nbsp + "млн"}, {1e9, nbsp + "млрд"}, {1e12, nbsp + "трлн"}}},
+	"pl": {group: nbsp, decimal: ",", minGrouping: 2, currencyAfter: true, currencySpace: true,
+		compact: []compactUnit{{1e3, nbsp + "tys."}, {1e6, nbsp + "mln"}, {1e9, nbsp + "mld"}, {1e12, nbsp + "bln"}}},
+	"sv": {group: nbsp, decimal: ",", currencyAfter: true, currencySpace: true, percentSpace: true,
+		compact: []compactUnit{{1e3, nbsp + "tn"}, {1e6, nbsp + "mn"}, {1e9, nbsp + "md"}, {1e12, nbsp + "bn"}}},
+	"ja": {group: ",", decimal: ".", compact: []compactUnit{{1e4, "万"}, {1e8, "億"}, {1e12, "兆"}}},
+	"zh": {group: ",", decimal: ".", compact: []compactUnit{{1e4, "万"}, {1e8, "亿"}, {1e12, "万亿"}}},
+	"ko": {group: ",", decimal: ".", compact: []compactUnit{{1e3, "천"}, {1e4, "만"}, {1e8, "억"}, {1e12, "조"}}},
+}
+
+//currencySymbols are the symbols of currencies by ISO 4217 code, the code is the symbol of other currencies
+var currencySymbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "CNY": "¥", "KRW": "₩", "INR": "₹", "RUB": "₽",
+	"BRL": "R$", "CAD": "CA$", "AUD": "A$", "PLN": "zł", "SEK": "kr", "CHF": "CHF",
+}
+
+//currencyDigits are the numbers of fraction digits of the currencies which are not 2
+var currencyDigits = map[string]int{"JPY": 0, "KRW": 0}
+
+//numberFormatOf returns the number format of the first locale of opts which has one
+func numberFormatOf(opts *options) *numberFormat {
+	if opts != nil {
+		for _, l := range localeChain(opts.locales) {
+			if nf, ok := numberFormats[l]; ok {
+				return nf
+			}
+		}
+	}
+	return numberFormats["en"]
+}
+
+//format writes v with the separators of the locale, decimals is the number of fraction digits,
+//if it is negative the fraction is rounded to at most 3 digits and the trailing zeros are removed
+func (nf *numberFormat) format(v reflect.Value, decimals int) string {
+	var s string
+	switch {
+	case isIntValue(v) && decimals <= 0:
+		s = strconv.FormatInt(v.Int(), 10)
+	case isUintValue(v) && decimals <= 0:
+		s = strconv.FormatUint(v.Uint(), 10)
+	case decimals < 0:
+		s = strconv.FormatFloat(toFloat(v), 'f', 3, 64)
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	default:
+		s = strconv.FormatFloat(toFloat(v), 'f', decimals, 64)
+	}
+	return nf.separate(s)
+}
+
+//separate replaces the separators of s, which is formatted by strconv, with the separators of the locale
+func (nf *numberFormat) separate(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	// -0 is 0 after rounding
+	if strings.Trim(s, "0.") == "" {
+		sign = ""
+	}
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	size2 := nf.groupSize2
+	if size2 == 0 {
+		size2 = 3
+	}
+	minGrouping := nf.minGrouping
+	if minGrouping == 0 {
+		minGrouping = 1
+	}
+	if len(intPart) >= 3+minGrouping {
+		groups := []string{intPart[len(intPart)-3:]}
+		rest := intPart[:len(intPart)-3]
+		for len(rest) > size2 {
+			groups = append(groups, rest[len(rest)-size2:])
+			rest = rest[:len(rest)-size2]
+		}
+		groups = append(groups, rest)
+		for i, j := 0, len(groups)-1; i < j; i, j = i+1, j-1 {
+			groups[i], groups[j] = groups[j], groups[i]
+		}
+		intPart = strings.Join(groups, nf.group)
+	}
+	if frac != "" {
+		return sign + intPart + nf.decimal + frac
+	}
+	return sign + intPart
+}
+
+func toNumber(name string, v interface{}) (reflect.Value, error) {
+	val := reflect.ValueOf(v)
+	if !isNumberValue(val) {
+		return val, fmt.Errorf("nbfmt.%s() error: %v (%T) is not a number", name, v, v)
+	}
+	return val, nil
+}
+
+func optionalDecimals(name string, decimals []int64, def int) (int, error) {
+	switch {
+	case len(decimals) == 0:
+		return def, nil
+	case len(decimals) > 1 || decimals[0] < 0:
+		return 0, fmt.Errorf("nbfmt.%s() error: invalid number of decimals (%v)", name, decimals)
+	default:
+		return int(decimals[0]), nil
+	}
+}
+
+//numberFunc is number(x) or number(x, decimals), it writes x with the grouping and decimal separators of the locale,
+//by default the fraction is rounded to at most 3 digits
+func numberFunc(sc *scope, v interface{}, decimals ...int64) (string, error) {
+	val, err := toNumber("numberFunc", v)
+	if err != nil {
+		return "", err
+	}
+	d, err := optionalDecimals("numberFunc", decimals, -1)
+	if err != nil {
+		return "", err
+	}
+	return numberFormatOf(sc.opts).format(val, d), nil
+}
+
+//currencyFunc is currency(x, code) or currency(x, code, decimals), it writes the amount x of the currency (e.g. "USD")
+//with the symbol placed by the locale, the number of decimals is the one of the currency by default (0 for JPY)
+func currencyFunc(sc *scope, v interface{}, code string, decimals ...int64) (string, error) {
+	val, err := toNumber("currencyFunc", v)
+	if err != nil {
+		return "", err
+	}
+	code = strings.ToUpper(code)
+	def, ok := currencyDigits[code]
+	if !ok {
+		def = 2
+	}
+	d, err := optionalDecimals("currencyFunc", decimals, def)
+	if err != nil {
+		return "", err
+	}
+	symbol, ok := currencySymbols[code]
+	if !ok {
+		symbol = code
+	}
+	nf := numberFormatOf(sc.opts)
+	num := nf.format(val, d)
+	sign := ""
+	if strings.HasPrefix(num, "-") {
+		sign, num = "-", num[1:]
+	}
+	space := ""
+	if nf.currencySpace {
+		space = nbsp
+	}
+	if nf.currencyAfter {
+		return sign + num + space + symbol, nil
+	}
+	return sign + symbol + space + num, nil
+}
+
+//percentFunc is percent(x) or percent(x, decimals), it writes the ratio x as percentage, e.g. percent(0.256) is 26%
+func percentFunc(sc *scope, v interface{}, decimals ...int64) (string, error) {
+	val, err := toNumber("percentFunc", v)
+	if err != nil {
+		return "", err
+	}
+	d, err := optionalDecimals("percentFunc", decimals, 0)
+	if err != nil {
+		return "", err
+	}
+	nf := numberFormatOf(sc.opts)
+	num := nf.format(reflect.ValueOf(toFloat(val)*100), d)
+	if nf.percentSpace {
+		return num + nbsp + "%", nil
+	}
+	return num + "%", nil
+}
+
+//compactFunc is compact(x), it writes x by the largest unit of the locale which is not greater than x,
+//with one decimal below 10 of the unit, e.g. 1.2K, 15K and 3.4M in English and 1.2万 in Chinese
+func compactFunc(sc *scope, v interface{}) (string, error) {
+	val, err := toNumber("compactFunc", v)
+	if err != nil {
+		return "", err
+	}
+	nf := numberFormatOf(sc.opts)
+	x := toFloat(val)
+	unit := -1
+	for i, u := range nf.compact {
+		if math.Abs(x) >= u.value {
+			unit = i
+		}
+	}
+	if unit < 0 {
+		return nf.format(val, -1), nil
+	}
+	for {
+		q := x / nf.compact[unit].value
+		d := 0
+		if math.Abs(q) < 10 {
+			d = 1
+		}
+		p := math.Pow(10, float64(d))
+		rounded := math.Round(q*p) / p
+		// 999999 is 1M rather than 1000K
+		if unit+1 < len(nf.compact) && math.Abs(rounded*nf.compact[unit].value) >= nf.compact[unit+1].value {
+			unit++
+			continue
+		}
+		num := strings.TrimSuffix(strconv.FormatFloat(rounded, 'f', d, 64), ".0")
+		return nf.separate(num) + nf.compact[unit].suffix, nil
+	}
+}
@@ -0,0 +1,71 @@
+package nbfmt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNumberFuncs(t *testing.T) {
+	env := map[string]interface{}{
+		"big":   1234567.5,
+		"count": 1234567,
+		"price": 1234.5,
+		"ratio": 0.256,
+		"neg":   -9876.543,
+	}
+	tests := []struct {
+		src    string
+		locale string
+		want   string
+	}{
+		{`{{ number(big) }} {{ number(count) }} {{ number(neg) }} {{ number(12) }}`, "en", "1,234,567.5 1,234,567 -9,876.543 12"},
+		{`{{ number(big, 2) }} {{ number(count, 1) }} {{ number(0.0001) }} {{ number(-0.0001, 2) }}`, "en", "1,234,567.50 1,234,567.0 0 0.00"},
+		{`{{ number(big) }}`, "de-DE", "1.234.567,5"},
+		{`{{ number(big) }}`, "fr", "1 234 567,5"},
+		{`{{ number(big) }}`, "ru", "1_234_567,5"},
+		{`{{ number(big) }}`, "de-CH", "1’234’567.5"},
+		{`{{ number(count) }}`, "en-IN", "12,34,567"},
+		{`{{ number(1234) }} {{ number(12345) }}`, "es", "1234 12.345"},
+		{`{{ number(big) }}`, "xx", "1,234,567.5"},
+		{`{{ currency(price, "USD") }} {{ currency(neg, "usd") }}`, "en-US", "$1,234.50 -$9,876.54"},
+		{`{{ currency(price, "EUR") }}`, "de", "1.234,50_€"},
+		{`{{ currency(price, "EUR") }}`, "nl", "€_1.234,50"},
+		{`{{ currency(price, "BRL") }}`, "pt-BR", "R$_1.234,50"},
+		{`{{ currency(price, "JPY") }} {{ currency(price, "JPY", 2) }}`, "ja", "¥1,234 ¥1,234.50"},
+		{`{{ currency(price, "XYZ") }}`, "en", "XYZ1,234.50"},
+		{`{{ percent(ratio) }} {{ percent(ratio, 1) }} {{ percent(2) }}`, "en", "26% 25.6% 200%"},
+		{`{{ percent(ratio, 1) }}`, "fr", "25,6_%"},
+		{`{{ compact(999) }} {{ compact(1234) }} {{ compact(15300) }} {{ compact(999999) }} {{ compact(big) }} {{ compact(-2500000000) }}`, "en", "999 1.2K 15K 1M 1.2M -2.5B"},
+		{`{{ compact(12345) }} {{ compact(123456789) }}`, "zh-CN", "1.2万 1.2亿"},
+		{`{{ compact(12345) }}`, "ja", "1.2万"},
+		{`{{ compact(big) }}`, "de", "1,2_Mio."},
+		{`{{ compact(123456) }} {{ compact(12345678) }}`, "en-IN", "1.2L 1.2Cr"},
+	}
+	for _, test := range tests {
+		temp, err := Parse(test.src)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		got, err := temp.Execute(env, Locale(test.locale))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		// _ stands for the no-break space in the expected results
+		if want := strings.Replace(test.want, "_", nbsp, -1); got != want {
+			t.Errorf("%s (%s): got %q, want %q", test.src, test.locale, got, want)
+		}
+	}
+	for _, src := range []string{
+		`{{ number("1") }}`,
+		`{{ number(big, -1) }}`,
+		`{{ currency(price) }}`,
+		`{{ percent(nil) }}`,
+		`{{ compact("1K") }}`,
+	} {
+		if got, err := Fmt(src, env); err == nil {
+			t.Errorf("%s: expected error, got %q", src, got)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package nbfmt
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Option changes how a template is executed, it is passed to Parse or Fmt
+type Option func(*options)
+
+type options struct {
+	//fieldTag is the struct tag whose names are used by dot access before the Go names of fields
+	fieldTag string
+	//foldFieldCase makes dot access match the names of fields case-insensitively
+	foldFieldCase bool
+	//clock returns the current time for now() and ago(), time.Now is used if it is nil
+	clock func() time.Time
+	//catalog holds the messages of t statements
+	catalog *Catalog
+	//locales are the locales whose messages are used by t statements in order
+	locales []string
+	//missing decides the values of the variables and map keys which do not exist
+	missing MissingMode
+}
+
+//MissingMode is what a variable which is not in env (or a key which is not in map) is evaluated to
+type MissingMode int
+
+const (
+	//MissingError makes the execution fail, it is the default
+	MissingError MissingMode = iota
+	//MissingZero evaluates the missing values to nil
+	MissingZero
+	//MissingEmpty evaluates the missing values to the empty string
+	MissingEmpty
+	//MissingKeep renders the value statements using missing values as they are (e.g. {{ user.Name }}) and fails otherwise,
+	//so the output can be rendered again with more data
+	MissingKeep
+)
+
+//FieldTag makes dot access resolve the names in the struct tag first, e.g. with FieldTag("json")
+//the field `CreatedAt time.Time `json:"created_at"`` is accessed by {{ v.created_at }}, the Go names of fields still work
+func FieldTag(tag string) Option {
+	return func(o *options) {
+		o.fieldTag = tag
+	}
+}
+
+//CaseInsensitiveFields makes dot access match the names of fields (and the names in the struct tag) case-insensitively,
+//the exact match takes precedence
+func CaseInsensitiveFields() Option {
+	return func(o *options) {
+		o.foldFieldCase = true
+	}
+}
+
+//Clock makes now() and ago() read the current time from clock, so the templates using them can be rendered
+//deterministically in tests, e.g. temp.Execute(env, nbfmt.Clock(func() time.Time { return fixed }))
+func Clock(clock func() time.Time) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+//Translations makes t statements use the messages of the catalog
+func Translations(catalog *Catalog) Option {
+	return func(o *options) {
+		o.catalog = catalog
+	}
+}
+
+//Locale sets the locales of t statements, the messages are searched in the locales and their parents in order,
+//e.g. with Locale("pt-BR", "en") in pt-br, pt and en. It is usually given to Template.Execute for each user
+func Locale(locales ...string) Option {
+	return func(o *options) {
+		o.locales = locales
+	}
+}
+
+//Missing sets what the variables and map keys which do not exist are evaluated to, e.g. Missing(MissingEmpty)
+//renders {{ user.Nickname }} as the empty string if user has no Nickname key
+func Missing(mode MissingMode) Option {
+	return func(o *options) {
+		o.missing = mode
+	}
+}
+
+//structFields are the names of the fields of a struct type, the promoted fields of embedded structs are included,
+//Index of the fields is the full index path from the struct
+type structFields struct {
+	byTag      map[string]reflect.StructField
+	byName     map[string]reflect.StructField
+	byFoldTag  map[string]reflect.StructField
+	byFoldName map[string]reflect.StructField
+}
+
+type fieldsKey struct {
+	typ reflect.Type
+	tag string
+}
+
+//fieldsCache caches structFields by type and tag, so the tags of a type are parsed only once
+var fieldsCache sync.Map
+
+//lookupField finds the field of struct type typ by name, the names in the tag of opts are tried before the Go names
+func lookupField(typ reflect.Type, name string, opts *options) (reflect.StructField, bool) {
+	if opts == nil || (opts.fieldTag == "" && !opts.foldFieldCase) {
+		return typ.FieldByName(name)
+	}
+	key := fieldsKey{typ, opts.fieldTag}
+	cached, ok := fieldsCache.Load(key)
+	if !ok {
+		cached, _ = fieldsCache.LoadOrStore(key, newStructFields(typ, opts.fieldTag))
+	}
+	fields := cached.(*structFields)
+	candidates := []map[string]reflect.StructField{fields.byTag, fields.byName}
+	if opts.foldFieldCase {
+		candidates = append(candidates, fields.byFoldTag, fields.byFoldName)
+	}
+	for i, m := range candidates {
+		n := name
+		if i >= 2 {
+			n = strings.ToLower(name)
+		}
+		if sf, ok := m[n]; ok {
+			return sf, sf.Index != nil
+		}
+	}
+	// unexported fields are reported by FieldByName
+	return typ.FieldByName(name)
+}
+
+func newStructFields(typ reflect.Type, tag string) *structFields {
+	fields := &structFields{
+		byTag:      make(map[string]reflect.StructField),
+		byName:     make(map[string]reflect.StructField),
+		byFoldTag:  make(map[string]reflect.StructField),
+		byFoldName: make(map[string]reflect.StructField),
+	}
+	// the embedded structs are visited breadth first, so the shallower fields shadow the deeper ones like Go
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+	level := []embedded{{typ, nil}}
+	visited := map[reflect.Type]bool{typ: true}
+	for len(level) > 0 {
+		var next []embedded
+		names := make(map[string][]reflect.StructField)
+		tagged := make(map[string][]reflect.StructField)
+		for _, e := range level {
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				sf.Index = append(append([]int{}, e.index...), i)
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && ft.Kind() == reflect.Struct && !visited[ft] {
+					visited[ft] = true
+					next = append(next, embedded{ft, sf.Index})
+				}
+				if sf.PkgPath != "" {
+					continue
+				}
+				names[sf.Name] = append(names[sf.Name], sf)
+				if tag == "" {
+					continue
+				}
+				if tagName := strings.Split(sf.Tag.Get(tag), ",")[0]; tagName != "" && tagName != "-" {
+					tagged[tagName] = append(tagged[tagName], sf)
+				}
+			}
+		}
+		addFields(fields.byTag, tagged, false)
+		addFields(fields.byFoldTag, tagged, true)
+		addFields(fields.byName, names, false)
+		addFields(fields.byFoldName, names, true)
+		level = next
+	}
+	return fields
+}
+
+//addFields adds the fields of one depth to m, the names which are already in m belong to shallower fields,
+//the names shared by several fields of the same depth are ambiguous and they are marked by a field without Index
+func addFields(m map[string]reflect.StructField, level map[string][]reflect.StructField, fold bool) {
+	merged := make(map[string][]reflect.StructField, len(level))
+	for name, l := range level {
+		if fold {
+			name = strings.ToLower(name)
+		}
+		merged[name] = append(merged[name], l...)
+	}
+	for name, l := range merged {
+		if _, ok := m[name]; ok {
+			continue
+		}
+		if len(l) > 1 {
+			m[name] = reflect.StructField{}
+			continue
+		}
+		m[name] = l[0]
+	}
+}
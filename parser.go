@@ -12,6 +12,7 @@ import (
 
 var intRe = regexp.MustCompile(`^-?\d+$`)
 var floatRe = regexp.MustCompile(`^-?\d+\.\d+$`)
+var durationRe = regexp.MustCompile(`^-?(\d+(\.\d+)?(ns|us|ms|s|m|h|d|w))+$`)
 var varIdentRe = regexp.MustCompile(`^[a-zA-z_][\w_]*$`)
 var chrIdentRe = regexp.MustCompile(`^'.'$`)
 var strIdentRe = regexp.MustCompile("^[\"|`].*[\"|`]$")
@@ -41,6 +42,12 @@ func parseIdent(s string) (*ident, error) {
 		return &ident{src: s, typ: defaultIdent}, nil
 	case "endswitch":
 		return &ident{src: s, typ: endswitchIdent}, nil
+	case "break":
+		return &ident{src: s, typ: breakIdent}, nil
+	case "continue":
+		return &ident{src: s, typ: continueIdent}, nil
+	case "fallthrough":
+		return &ident{src: s, typ: fallthroughIdent}, nil
 	case ".":
 		return &ident{src: s, typ: dotIdent}, nil
 	case ",":
@@ -81,6 +88,18 @@ func parseIdent(s string) (*ident, error) {
 		return &ident{src: s, typ: orIdent}, nil
 	case "nil":
 		return &ident{src: s, typ: nilIdent}, nil
+	case "..":
+		return &ident{src: s, typ: rangeIdent}, nil
+	case ":":
+		return &ident{src: s, typ: colonIdent}, nil
+	case "?.":
+		return &ident{src: s, typ: optionalDotIdent}, nil
+	case "?[":
+		return &ident{src: s, typ: optionalBracketIdent}, nil
+	case "{":
+		return &ident{src: s, typ: leftBraceIdent}, nil
+	case "}":
+		return &ident{src: s, typ: rightBraceIdent}, nil
 	default:
 		switch {
 		case boolIdentRe.MatchString(s):
@@ -89,6 +108,8 @@ func parseIdent(s string) (*ident, error) {
 			return &ident{src: s, typ: intIdent}, nil
 		case floatRe.MatchString(s):
 			return &ident{src: s, typ: floatIdent}, nil
+		case durationRe.MatchString(s):
+			return &ident{src: s, typ: durationIdent}, nil
 		case varIdentRe.MatchString(s):
 			return &ident{src: s, typ: varIdent}, nil
 		case chrIdentRe.MatchString(s):
@@ -101,13 +122,83 @@ func parseIdent(s string) (*ident, error) {
 	}
 }
 
+//splitArgs splits the body of statement by the spaces which are not in string literals, parentheses, brackets
+//or braces, e.g. t "key" f(a, b) [1, 2] is split into t, "key", f(a, b) and [1, 2]
+func splitArgs(src string) []string {
+	var l []string
+	var depth int
+	var quote byte
+	start := -1
+	for i := 0; i < len(src); i++ {
+		b := src[i]
+		switch {
+		case quote != 0:
+			if b == '\\' && quote != '`' {
+				i++
+			} else if b == quote {
+				quote = 0
+			}
+			continue
+		case b == '"' || b == '`' || b == '\'':
+			quote = b
+		case b == '(' || b == '[' || b == '{':
+			depth++
+		case b == ')' || b == ']' || b == '}':
+			depth--
+		case (b == ' ' || b == '\t' || b == '\n' || b == '\r') && depth == 0:
+			if start >= 0 {
+				l = append(l, src[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		l = append(l, src[start:])
+	}
+	return l
+}
+
+//transArgs tokenizes the key and the arguments of t "key" arg1 arg2... one by one, so an argument is not joined
+//with the next one (e.g. a -1 is not a-1). It returns nil if the statement is not a t statement
+func transArgs(src string) [][]*ident {
+	parts := splitArgs(src)
+	if len(parts) < 2 || parts[0] != "t" {
+		return nil
+	}
+	args := make([][]*ident, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		s := &stmt{src: "{{" + part + "}}"}
+		if err := parseIdents([]*stmt{s}); err != nil || len(s.idents) == 0 {
+			return nil
+		}
+		args = append(args, s.idents)
+	}
+	// {{ t + 1 }} and {{ t in l }} are the expressions of t
+	if typ := args[0][0].typ; typ != strIdent && typ != varIdent {
+		return nil
+	}
+	return args
+}
+
 func parseIdents(l []*stmt) error {
 OUTER:
 	for _, s := range l {
 		if len(s.src) < 2 || s.src[:2] != "{{" {
 			continue
 		}
-		reader := strings.NewReader(strings.Trim(s.src, "{} "))
+		if args := transArgs(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s.src, "{{"), "}}"))); args != nil {
+			s.args = args
+			s.idents = []*ident{{src: "t", typ: varIdent}}
+			for _, arg := range args {
+				s.idents = append(s.idents, arg...)
+			}
+			continue
+		}
+		reader := strings.NewReader(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s.src, "{{"), "}}")))
 		builder := strings.Builder{}
 		ctx := "empty"
 		reflush := func() error {
@@ -125,9 +216,10 @@ OUTER:
 				return "empty"
 			}
 			switch s.idents[len(s.idents)-1].typ {
-			case ifIdent, elseifIdent, elseIdent, endifIdent, forIdent, inIdent, endforIdent, switchIdent, caseIdent, defaultIdent, endswitchIdent:
+			case ifIdent, elseifIdent, elseIdent, endifIdent, forIdent, inIdent, endforIdent, switchIdent, caseIdent, defaultIdent, endswitchIdent,
+				breakIdent, continueIdent, fallthroughIdent:
 				return "keyword"
-			case intIdent:
+			case intIdent, durationIdent:
 				return "int"
 			case floatIdent:
 				return "float"
@@ -137,10 +229,13 @@ OUTER:
 				return "str"
 			case varIdent:
 				return "var"
-			case leftBracketIdent, rightBracketIdent, leftParenthesisIdent, rightParenthesisIdent, commaIdent:
+			case byteIdent:
+				return "chr"
+			case leftBracketIdent, rightBracketIdent, leftParenthesisIdent, rightParenthesisIdent, commaIdent, colonIdent,
+				leftBraceIdent, rightBraceIdent, optionalBracketIdent:
 				return "punctuation"
 			case dotIdent, lessThanIdent, lessThanEqualIdent, greatThanIdent, greatThanEqualIdent, notEqualIdent, equalIdent, andIdent, orIdent,
-				asteriskIdent, plugIdent, subIdent, divIdent:
+				asteriskIdent, plugIdent, subIdent, divIdent, exclamationIdent, rangeIdent, optionalDotIdent:
 				return "operator"
 			case nilIdent:
 				return "nil"
@@ -185,7 +280,7 @@ OUTER:
 				case "keyword":
 					ctx = "var"
 					builder.WriteByte(b)
-				case "var", "int", "float":
+				case "var", "int", "float", "duration":
 					builder.WriteByte(b)
 				case "operator", "punctuation":
 					err := reflush()
@@ -221,7 +316,7 @@ OUTER:
 					}
 					ctx = "int"
 					builder.WriteByte(b)
-				case "int", "float", "var":
+				case "int", "float", "var", "duration":
 					err := reflush()
 					if err != nil {
 						return err
@@ -251,6 +346,13 @@ OUTER:
 					}
 				case "byte":
 					builder.WriteByte(b)
+				case "operator", "punctuation":
+					err := reflush()
+					if err != nil {
+						return err
+					}
+					ctx = "str"
+					builder.WriteByte(b)
 				default:
 					builder.WriteByte(b)
 					return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
@@ -299,6 +401,45 @@ OUTER:
 				case "int":
 					ctx = "float"
 					builder.WriteByte(b)
+				case "float":
+					// the second dot of 1..10 makes the range operator
+					num := builder.String()
+					if num[len(num)-1] != '.' {
+						builder.WriteByte(b)
+						return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
+					}
+					builder.Reset()
+					builder.WriteString(num[:len(num)-1])
+					err := reflush()
+					if err != nil {
+						return err
+					}
+					ctx = "operator"
+					builder.WriteString("..")
+				case "operator":
+					// ".." or "?."
+					if builder.String() != "." && builder.String() != "?" {
+						builder.WriteByte(b)
+						return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
+					}
+					builder.WriteByte(b)
+				case "punctuation":
+					// field of an index or call result, e.g. l[0].Name
+					err := reflush()
+					if err != nil {
+						return err
+					}
+					ctx = "operator"
+					builder.WriteByte(b)
+				case "empty":
+					switch checkPrev() {
+					case "var", "punctuation", "int":
+						ctx = "operator"
+						builder.WriteByte(b)
+					default:
+						builder.WriteByte(b)
+						return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
+					}
 				default:
 					builder.WriteByte(b)
 					return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
@@ -307,21 +448,39 @@ OUTER:
 				switch ctx {
 				case "empty":
 					switch checkPrev() {
-					case "empty", "var", "str", "int", "float", "bool", "punctuation", "keyword":
+					case "empty", "var", "str", "chr", "int", "float", "bool", "nil", "punctuation", "keyword":
+						ctx = "operator"
+						builder.WriteByte(b)
+					case "operator":
+						// unary not can follow any operator (e.g. a && !b)
+						if b != '!' {
+							builder.WriteByte(b)
+							return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
+						}
 						ctx = "operator"
 						builder.WriteByte(b)
 					default:
 						builder.WriteByte(b)
 						return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
 					}
-				case "var", "int", "float", "bool":
+				case "var", "int", "float", "bool", "duration":
 					err := reflush()
 					if err != nil {
 						return err
 					}
 					ctx = "operator"
 					builder.WriteByte(b)
-				case "operator", "str", "byte":
+				case "operator":
+					// '!' is never the tail of an operator, so it always starts a new one
+					if b == '!' {
+						err := reflush()
+						if err != nil {
+							return err
+						}
+						ctx = "operator"
+					}
+					builder.WriteByte(b)
+				case "str", "byte":
 					builder.WriteByte(b)
 				case "punctuation":
 					err := reflush()
@@ -334,11 +493,16 @@ OUTER:
 					builder.WriteByte(b)
 					return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
 				}
-			case '(', ')', '[', ']':
+			case '(', ')', '[', ']', '{', '}':
+				if b == '[' && ctx == "operator" && builder.String() == "?" {
+					ctx = "punctuation"
+					builder.WriteByte(b)
+					continue
+				}
 				switch ctx {
 				case "empty":
 					switch checkPrev() {
-					case "empty", "operator", "punctuation", "keyword":
+					case "empty", "operator", "punctuation", "keyword", "var", "str", "chr", "int", "float", "bool", "nil":
 						ctx = "punctuation"
 						builder.WriteByte(b)
 					default:
@@ -359,7 +523,7 @@ OUTER:
 				switch ctx {
 				case "empty":
 					switch checkPrev() {
-					case "var", "str", "chr", "int", "float", "bool":
+					case "var", "str", "chr", "int", "float", "bool", "nil", "punctuation":
 						ctx = "punctuation"
 						builder.WriteByte(b)
 					default:
@@ -376,30 +540,70 @@ OUTER:
 					ctx = "punctuation"
 					builder.WriteByte(b)
 				}
-			case ' ':
+			case '?':
 				switch ctx {
 				case "empty":
-					continue
+					switch checkPrev() {
+					case "var", "punctuation":
+						ctx = "operator"
+						builder.WriteByte(b)
+					default:
+						builder.WriteByte(b)
+						return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
+					}
 				case "str", "byte":
 					builder.WriteByte(b)
-				default:
+				case "var", "punctuation":
 					err := reflush()
 					if err != nil {
 						return err
 					}
+					ctx = "operator"
+					builder.WriteByte(b)
+				default:
+					builder.WriteByte(b)
+					return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
 				}
-			default:
+			case ':':
 				switch ctx {
 				case "empty":
+					// the low bound of slice expression can be omitted (e.g. s[:3])
 					switch checkPrev() {
-					case "int", "float", "bool":
+					case "var", "str", "chr", "int", "float", "bool", "nil", "punctuation":
+						ctx = "punctuation"
 						builder.WriteByte(b)
-						return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
 					default:
-						ctx = "var"
 						builder.WriteByte(b)
+						return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
 					}
-				case "str", "byte", "var":
+				case "str", "byte":
+					builder.WriteByte(b)
+				default:
+					err := reflush()
+					if err != nil {
+						return err
+					}
+					ctx = "punctuation"
+					builder.WriteByte(b)
+				}
+			case ' ':
+				switch ctx {
+				case "empty":
+					continue
+				case "str", "byte":
+					builder.WriteByte(b)
+				default:
+					err := reflush()
+					if err != nil {
+						return err
+					}
+				}
+			default:
+				switch ctx {
+				case "empty":
+					ctx = "var"
+					builder.WriteByte(b)
+				case "str", "byte", "var", "duration":
 					builder.WriteByte(b)
 				case "operator", "punctuation":
 					err := reflush()
@@ -408,6 +612,24 @@ OUTER:
 					}
 					ctx = "var"
 					builder.WriteByte(b)
+				case "int", "float":
+					// the unit after a number makes a duration literal (e.g. 7d, 1h30m)
+					if num := builder.String(); num[len(num)-1] >= '0' && num[len(num)-1] <= '9' {
+						ctx = "duration"
+						builder.WriteByte(b)
+						continue
+					}
+					// a single '-' before a variable is the negative operator
+					if builder.String() != "-" || ctx == "float" {
+						builder.WriteByte(b)
+						return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
+					}
+					err := reflush()
+					if err != nil {
+						return err
+					}
+					ctx = "var"
+					builder.WriteByte(b)
 				default:
 					builder.WriteByte(b)
 					return fmt.Errorf("nbfmt.parseIdents() error: invalid ident (%s) in statement (%s)\n", builder.String(), s)
@@ -420,6 +642,10 @@ OUTER:
 
 func parseStmt(src string) ([]*stmt, error) {
 	ctx := "temp"
+	// depth counts the braces of map literals and quote is the opening quote of the string literal in the statement,
+	// the braces in them do not end the statement
+	var depth int
+	var quote byte
 	buf := bytes.NewBuffer(make([]byte, 0, 128))
 	l := make([]*stmt, 0, 128)
 	reader := bufio.NewReader(strings.NewReader(src))
@@ -447,6 +673,28 @@ func parseStmt(src string) ([]*stmt, error) {
 				return nil, err
 			}
 		}
+		if ctx == "stmt" {
+			switch {
+			case quote != 0:
+				if char == quote {
+					quote = 0
+				}
+				buf.WriteByte(char)
+				continue
+			case char == '"' || char == '`' || char == '\'':
+				quote = char
+				buf.WriteByte(char)
+				continue
+			case char == '{':
+				depth++
+				buf.WriteByte(char)
+				continue
+			case char == '}' && depth > 0:
+				depth--
+				buf.WriteByte(char)
+				continue
+			}
+		}
 		switch char {
 		case '{':
 			switch ctx {
@@ -502,6 +750,9 @@ func parseStmt(src string) ([]*stmt, error) {
 				}
 				buf.WriteString("{{")
 				buf.WriteByte(char)
+				if char == '"' || char == '`' || char == '\'' {
+					quote = char
+				}
 			case "stmt":
 				buf.WriteByte(char)
 			default:
@@ -514,6 +765,12 @@ func parseStmt(src string) ([]*stmt, error) {
 
 func parseStmtType(l []*stmt) {
 	for _, s := range l {
+		// default( is the call of default function rather than the default keyword
+		for i, id := range s.idents {
+			if id.typ == defaultIdent && i+1 < len(s.idents) && s.idents[i+1].typ == leftParenthesisIdent {
+				id.typ = varIdent
+			}
+		}
 		switch len(s.idents) {
 		case 0:
 			s.typ = templatestmt
@@ -539,6 +796,19 @@ func parseStmtType(l []*stmt) {
 				s.typ = defaultstmt
 			case endswitchIdent:
 				s.typ = endswitchstmt
+			case breakIdent:
+				s.typ = breakstmt
+			case continueIdent:
+				s.typ = continuestmt
+			case fallthroughIdent:
+				s.typ = fallthroughstmt
+			case varIdent:
+				// t "key" args... is a translation, {{ t }} and the expressions of t are values
+				if s.args != nil {
+					s.typ = transstmt
+					break
+				}
+				s.typ = valuestmt
 			default:
 				s.typ = valuestmt
 			}
@@ -547,9 +817,13 @@ func parseStmtType(l []*stmt) {
 }
 
 func trimStmt(l []*stmt) {
+	if len(l) == 0 {
+		return
+	}
 	for i, s := range l[:len(l)-1] {
 		switch s.typ {
-		case ifstmt, elseifstmt, elsestmt, endifstmt, forstmt, endforstmt, switchstmt, casestmt, defaultstmt, endswitchstmt:
+		case ifstmt, elseifstmt, elsestmt, endifstmt, forstmt, endforstmt, switchstmt, casestmt, defaultstmt, endswitchstmt, breakstmt, continuestmt,
+			fallthroughstmt:
 			if l[i+1].typ == templatestmt {
 				if l[i+1].src[0] == '\n' {
 					l[i+1].src = l[i+1].src[1:]
@@ -559,142 +833,405 @@ func trimStmt(l []*stmt) {
 	}
 }
 
-func parseExpression(identList *[]*ident, isGroup bool, isIndex bool) (*expression, error) {
-	if len(*identList) == 0 {
-		return nil, nil
-	}
-	pop := func() *ident {
-		if len(*identList) == 0 {
-			return nil
+//exprParser is a Pratt parser which builds the expression tree from idents,
+//the priority of operator is used as binding power so operators of the same priority are left associative
+type exprParser struct {
+	idents []*ident
+	pos    int
+}
+
+func newExprParser(idents []*ident) *exprParser {
+	l := make([]*ident, 0, len(idents))
+	for _, id := range idents {
+		// the tokenizer reads "-1" after an operand (e.g. "l[0]-1") as a negative number, split it into a subtract operator and a number
+		if (id.typ == intIdent || id.typ == floatIdent || id.typ == durationIdent) && id.src[0] == '-' && len(l) > 0 && endsOperand(l[len(l)-1]) {
+			l = append(l, &ident{src: "-", typ: subIdent}, &ident{src: id.src[1:], typ: id.typ})
+			continue
 		}
-		id, remain := (*identList)[0], (*identList)[1:]
-		*identList = remain
-		return id
+		l = append(l, id)
 	}
+	return &exprParser{idents: l}
+}
 
-	push := func(id *ident) {
-		nl := make([]*ident, len(*identList)+1)
-		nl[0] = id
-		copy(nl[1:], *identList)
-		*identList = nl
+func endsOperand(id *ident) bool {
+	switch id.typ {
+	case varIdent, strIdent, byteIdent, intIdent, floatIdent, durationIdent, boolIdent, nilIdent, rightParenthesisIdent, rightBracketIdent,
+		rightBraceIdent:
+		return true
+	default:
+		return false
 	}
+}
 
-	peek := func() *ident {
-		if len(*identList) == 0 {
-			return nil
-		}
-		return (*identList)[0]
+func (p *exprParser) peek() *ident {
+	if p.pos >= len(p.idents) {
+		return nil
 	}
+	return p.idents[p.pos]
+}
 
-	str := func() string {
-		builder := strings.Builder{}
-		for _, id := range *identList {
-			builder.WriteString(id.src + " ")
+func (p *exprParser) next() *ident {
+	id := p.peek()
+	if id != nil {
+		p.pos++
+	}
+	return id
+}
+
+func (p *exprParser) String() string {
+	builder := strings.Builder{}
+	for i, id := range p.idents {
+		if i > 0 {
+			builder.WriteByte(' ')
 		}
-		return builder.String()
+		builder.WriteString(id.src)
 	}
-	e := &expression{}
-	id := pop()
-	// var deref bool
-	var unary bool
+	return builder.String()
+}
+
+func binaryOperator(id *ident) *operator {
 	switch id.typ {
 	case asteriskIdent:
-		nextIdent := peek()
-		if nextIdent == nil || nextIdent.typ != varIdent {
-			return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid asterisk ident (%s) in expression (%s)\n", id.src, str())
+		return &mulOperator
+	case divIdent:
+		return &divOperator
+	case plugIdent:
+		return &plugOperator
+	case subIdent:
+		return &subOperator
+	case equalIdent:
+		return &equalOperator
+	case notEqualIdent:
+		return &notEqualOperator
+	case lessThanIdent:
+		return &lessThanOperator
+	case lessThanEqualIdent:
+		return &lessThanEqualOperator
+	case greatThanIdent:
+		return &greatThanOperator
+	case greatThanEqualIdent:
+		return &greatThanEqualOperator
+	case andIdent:
+		return &andOperator
+	case orIdent:
+		return &orOperator
+	case rangeIdent:
+		return &rangeOperator
+	case inIdent:
+		return &inOperator
+	default:
+		return nil
+	}
+}
+
+//parse parses an expression whose operators all bind tighter than priority, it stops at the first ident which cannot continue the expression
+func (p *exprParser) parse(priority int) (expression, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		id := p.peek()
+		if id == nil {
+			return left, nil
 		}
-		e.operator = &derefOperator
-		// deref = true
-		unary = true
-	case exclamationIdent:
-		nextIdent := peek()
-		if nextIdent == nil || nextIdent.typ != varIdent {
-			return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid asterisk ident (%s) in expression (%s)\n", id.src, str())
+		switch id.typ {
+		case dotIdent, optionalDotIdent:
+			p.next()
+			f := p.next()
+			if f == nil || f.typ != varIdent {
+				return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid field after dot in expression (%s)\n", p)
+			}
+			left = &dotExpr{obj: left, field: f, optional: id.typ == optionalDotIdent}
+			continue
+		case leftBracketIdent, optionalBracketIdent:
+			p.next()
+			e, err := p.parseIndex(left, id.typ == optionalBracketIdent)
+			if err != nil {
+				return nil, err
+			}
+			left = e
+			continue
+		case leftParenthesisIdent:
+			p.next()
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			left = specialCall(&callExpr{fn: left, args: args})
+			continue
+		}
+		op := binaryOperator(id)
+		if op == nil || op.priority <= priority {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parse(op.priority)
+		if err != nil {
+			return nil, err
 		}
-		e.operator = &notOperator
-		// deref = true
-		unary = true
-	case varIdent, strIdent, byteIdent, intIdent, floatIdent, boolIdent, nilIdent:
-		e.ident = id
+		left = &binaryExpr{operator: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseOperand() (expression, error) {
+	id := p.next()
+	if id == nil {
+		return nil, fmt.Errorf("nbfmt.parseExpression() error: unexpected end of expression (%s)\n", p)
+	}
+	switch id.typ {
+	case varIdent:
+		return &varExpr{ident: id}, nil
+	case strIdent, byteIdent, intIdent, floatIdent, durationIdent, boolIdent, nilIdent:
+		v, err := id.eval(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExpr{ident: id, value: v}, nil
 	case leftParenthesisIdent:
-		subExpr, err := parseExpression(identList, true, false)
+		e, err := p.parse(0)
 		if err != nil {
 			return nil, err
 		}
-		e.subExpr = subExpr
-		subExpr.parExpr = e
+		if rp := p.next(); rp == nil || rp.typ != rightParenthesisIdent {
+			return nil, fmt.Errorf("nbfmt.parseExpression() error: missing right parenthesis in expression (%s)\n", p)
+		}
+		return e, nil
 	case leftBracketIdent:
-		subExpr, err := parseExpression(identList, false, true)
+		items, err := p.parseItems(rightBracketIdent, false)
 		if err != nil {
 			return nil, err
 		}
-		e.subExpr = subExpr
-		subExpr.parExpr = e
+		return &listExpr{items: items}, nil
+	case leftBraceIdent:
+		items, err := p.parseItems(rightBraceIdent, true)
+		if err != nil {
+			return nil, err
+		}
+		return &mapExpr{items: items}, nil
+	case exclamationIdent:
+		return p.parseUnary(&notOperator)
+	case asteriskIdent:
+		return p.parseUnary(&derefOperator)
+	case subIdent:
+		return p.parseUnary(&negOperator)
+	default:
+		return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid ident (%s) in expression (%s)\n", id.src, p)
+	}
+}
+
+//specialCall returns the expression of defined(x) or default(x, fallback), whose arguments are evaluated by the functions
+//themselves so the missing variables can be tested, the other calls are returned as they are
+func specialCall(call *callExpr) expression {
+	fn, ok := call.fn.(*varExpr)
+	if !ok {
+		return call
+	}
+	switch {
+	case fn.ident.src == "defined" && len(call.args) == 1:
+		return &definedExpr{call: call}
+	case fn.ident.src == "default" && len(call.args) == 2:
+		return &defaultExpr{call: call}
 	default:
-		return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid ident (%s) in expression (%s)\n", id.src, str())
+		return call
 	}
-	// if !deref {
-	if !unary {
-		op := pop()
-		if op == nil {
-			return e, nil
+}
+
+//parseIndex parses obj[index] or obj[low:high] (both bounds can be omitted), the left bracket has been consumed,
+//optional is true for obj?[index]
+func (p *exprParser) parseIndex(obj expression, optional bool) (expression, error) {
+	var low, high expression
+	var err error
+	if id := p.peek(); id == nil || id.typ != colonIdent {
+		if low, err = p.parse(0); err != nil {
+			return nil, err
+		}
+	}
+	id := p.next()
+	switch {
+	case id == nil:
+		return nil, fmt.Errorf("nbfmt.parseExpression() error: missing right bracket in expression (%s)\n", p)
+	case id.typ == rightBracketIdent:
+		if low == nil {
+			return nil, fmt.Errorf("nbfmt.parseExpression() error: missing index in expression (%s)\n", p)
+		}
+		return &indexExpr{obj: obj, index: low, optional: optional}, nil
+	case id.typ != colonIdent:
+		return nil, fmt.Errorf("nbfmt.parseExpression() error: missing right bracket in expression (%s)\n", p)
+	}
+	if id := p.peek(); id == nil || id.typ != rightBracketIdent {
+		if high, err = p.parse(0); err != nil {
+			return nil, err
+		}
+	}
+	if rb := p.next(); rb == nil || rb.typ != rightBracketIdent {
+		return nil, fmt.Errorf("nbfmt.parseExpression() error: missing right bracket in expression (%s)\n", p)
+	}
+	return &sliceExpr{obj: obj, low: low, high: high, optional: optional}, nil
+}
+
+//parseItems parses the items of list literal ([a, b]) or map literal ({"k": v}, the keys and the values are in turn)
+//until end, the opening bracket or brace has been consumed and a trailing comma is allowed
+func (p *exprParser) parseItems(end identType, keyed bool) ([]expression, error) {
+	items := make([]expression, 0, 4)
+	for {
+		if id := p.peek(); id != nil && id.typ == end {
+			p.next()
+			return items, nil
+		}
+		item, err := p.parse(0)
+		if err != nil {
+			return nil, err
 		}
-		switch op.typ {
-		case leftBracketIdent:
-			e.operator = &indexOperator
-			push(op)
-		case rightParenthesisIdent:
-			if isGroup {
-				return e, nil
+		items = append(items, item)
+		if keyed {
+			if colon := p.next(); colon == nil || colon.typ != colonIdent {
+				return nil, fmt.Errorf("nbfmt.parseExpression() error: missing colon after map key in expression (%s)\n", p)
 			}
-			return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid right parenthesis ident (%s) in expression (%s)\n", op.src, str())
-		case rightBracketIdent:
-			if isIndex {
-				return e, nil
+			value, err := p.parse(0)
+			if err != nil {
+				return nil, err
 			}
-			return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid right bracket ident (%s) in expression (%s)\n", op.src, str())
-		case dotIdent:
-			e.operator = &dotOperator
-		case lessThanIdent:
-			e.operator = &lessThanOperator
-		case lessThanEqualIdent:
-			e.operator = &lessThanEqualOperator
-		case greatThanIdent:
-			e.operator = &greatThanOperator
-		case greatThanEqualIdent:
-			e.operator = &greatThanEqualOperator
-		case equalIdent:
-			e.operator = &equalOperator
-		case notEqualIdent:
-			e.operator = &notEqualOperator
-		case plugIdent:
-			e.operator = &plugOperator
-		case subIdent:
-			e.operator = &subOperator
-		case asteriskIdent:
-			e.operator = &mulOperator
-		case divIdent:
-			e.operator = &divOperator
-		case andIdent:
-			e.operator = &andOperator
-		case orIdent:
-			e.operator = &orOperator
-		default:
-			return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid operator ident (%s) in expression (%s)\n", op.src, str())
+			items = append(items, value)
+		}
+		id := p.next()
+		switch {
+		case id == nil:
+			return nil, fmt.Errorf("nbfmt.parseExpression() error: unclosed literal in expression (%s)\n", p)
+		case id.typ == end:
+			return items, nil
+		case id.typ != commaIdent:
+			return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid ident (%s) in literal (%s)\n", id.src, p)
+		}
+	}
+}
+
+//parseArgs parses the arguments of a function call, the left parenthesis has been consumed
+func (p *exprParser) parseArgs() ([]expression, error) {
+	args := make([]expression, 0, 4)
+	if id := p.peek(); id != nil && id.typ == rightParenthesisIdent {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		id := p.next()
+		switch {
+		case id == nil:
+			return nil, fmt.Errorf("nbfmt.parseExpression() error: missing right parenthesis in expression (%s)\n", p)
+		case id.typ == rightParenthesisIdent:
+			return args, nil
+		case id.typ != commaIdent:
+			return nil, fmt.Errorf("nbfmt.parseExpression() error: invalid ident (%s) in arguments (%s)\n", id.src, p)
 		}
 	}
-	nextExpr, err := parseExpression(identList, isGroup, isIndex)
+}
+
+func (p *exprParser) parseUnary(op *operator) (expression, error) {
+	operand, err := p.parse(op.priority)
 	if err != nil {
 		return nil, err
 	}
-	if nextExpr == nil {
-		return e, nil
+	return &unaryExpr{operator: op, operand: operand}, nil
+}
+
+func parseExpression(idents []*ident) (expression, error) {
+	p := newExprParser(idents)
+	e, err := p.parse(0)
+	if err != nil {
+		return nil, err
+	}
+	if id := p.peek(); id != nil {
+		return nil, fmt.Errorf("nbfmt.parseExpression() error: unexpected ident (%s) in expression (%s)\n", id.src, p)
 	}
-	e.nextExpr = nextExpr
-	nextExpr.prevExpr = e
 	return e, nil
 }
 
+//parseExpressionPrefix parses the expression at the beginning of idents and returns the remaining idents
+func parseExpressionPrefix(idents []*ident) (expression, []*ident, error) {
+	p := newExprParser(idents)
+	e, err := p.parse(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, p.idents[p.pos:], nil
+}
+
+//parseExpressionList parses comma separated expressions
+func parseExpressionList(idents []*ident) ([]expression, error) {
+	p := newExprParser(idents)
+	l := make([]expression, 0, 4)
+	for {
+		e, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, e)
+		id := p.next()
+		if id == nil {
+			return l, nil
+		}
+		if id.typ != commaIdent {
+			return nil, fmt.Errorf("nbfmt.parseExpressionList() error: unexpected ident (%s) in expression list (%s)\n", id.src, p)
+		}
+	}
+}
+
+//parseCaseList parses the items of a case statement, an item is an expression or a comparison operator (or in)
+//followed by an expression, e.g. case > 100, in [1, 2], the operator of a plain expression is nil
+func parseCaseList(idents []*ident) ([]*operator, []expression, error) {
+	p := newExprParser(idents)
+	ops := make([]*operator, 0, 4)
+	exps := make([]expression, 0, 4)
+	for {
+		var op *operator
+		if id := p.peek(); id != nil {
+			switch o := binaryOperator(id); o {
+			case &equalOperator, &notEqualOperator, &lessThanOperator, &lessThanEqualOperator, &greatThanOperator,
+				&greatThanEqualOperator, &inOperator:
+				op = o
+				p.next()
+			}
+		}
+		e, err := p.parse(0)
+		if err != nil {
+			return nil, nil, err
+		}
+		ops = append(ops, op)
+		exps = append(exps, e)
+		id := p.next()
+		if id == nil {
+			return ops, exps, nil
+		}
+		if id.typ != commaIdent {
+			return nil, nil, fmt.Errorf("nbfmt.parseCaseList() error: unexpected ident (%s) in case list (%s)\n", id.src, p)
+		}
+	}
+}
+
+//parseTypeList parses the type names of a case statement in type switch, e.g. case int, []string, time.Time
+func parseTypeList(idents []*ident) ([]string, error) {
+	var types []string
+	builder := strings.Builder{}
+	for i, id := range idents {
+		if id.typ != commaIdent {
+			builder.WriteString(id.src)
+		}
+		if id.typ == commaIdent || i == len(idents)-1 {
+			if builder.Len() == 0 {
+				return nil, fmt.Errorf("nbfmt.parseTypeList() error: empty type name in case list\n")
+			}
+			types = append(types, builder.String())
+			builder.Reset()
+		}
+	}
+	return types, nil
+}
+
 func genIfCaseBlock(ss *stmtStack) (*ifcaseBlock, error) {
 	icb := &ifcaseBlock{}
 	s := ss.pop()
@@ -704,8 +1241,7 @@ func genIfCaseBlock(ss *stmtStack) (*ifcaseBlock, error) {
 		if len(s.idents) < 2 {
 			return nil, fmt.Errorf("nbfmt.genIfCaseBlock() parse error: invalid if case statement (%s)\n", s)
 		}
-		exprIdentList := s.idents[1:]
-		expr, err := parseExpression(&exprIdentList, false, false)
+		expr, err := parseExpression(s.idents[1:])
 		if err != nil {
 			return nil, err
 		}
@@ -722,7 +1258,7 @@ OUTER:
 		case elseifstmt, elsestmt, endifstmt:
 			ctx = "finish"
 			break OUTER
-		case ifstmt, forstmt, switchstmt, templatestmt, valuestmt:
+		case ifstmt, forstmt, switchstmt, templatestmt, valuestmt, transstmt, breakstmt, continuestmt:
 			subBlock, err := genBlock(ss)
 			if err != nil {
 				return nil, err
@@ -742,36 +1278,28 @@ OUTER:
 	return icb, nil
 }
 
-func genDefaultBlock(ss *stmtStack) (*defaultBlock, error) {
+//genDefaultBlock generates the else block of if and for block or the default block of switch block, endType is the statement type which ends the block
+func genDefaultBlock(ss *stmtStack, endType stmtType) (*defaultBlock, error) {
 	db := &defaultBlock{}
-	defaultType := ss.checkType()
 	s := ss.pop()
 	db.appendSrc(s.src)
 	ctx := "start"
 OUTER:
 	for ss.len() > 0 {
-		switch ss.checkType() {
-		case ifstmt, forstmt, switchstmt, templatestmt, valuestmt:
+		switch st := ss.checkType(); st {
+		case ifstmt, forstmt, switchstmt, templatestmt, valuestmt, transstmt, breakstmt, continuestmt:
 			subBlock, err := genBlock(ss)
 			if err != nil {
 				return nil, err
 			}
 			db.subBlocks = append(db.subBlocks, subBlock)
 			db.appendSrc(subBlock.getSrc())
-		case endifstmt:
-			if defaultType == elsestmt {
-				ctx = "finish"
-				break OUTER
-			} else {
-				return nil, errors.New("nbfmt.genDefaultBlock() parse error: invalid endif statement")
-			}
-		case endswitchstmt:
-			if defaultType == defaultstmt {
-				ctx = "finish"
-				break OUTER
-			} else {
-				return nil, errors.New("nbfmt.genDefaultBlock() parse error: invalid endswitch statement")
+		case endifstmt, endswitchstmt, endforstmt:
+			if st != endType {
+				return nil, fmt.Errorf("nbfmt.genDefaultBlock() parse error: invalid end statement (%s)\n", ss.pop().src)
 			}
+			ctx = "finish"
+			break OUTER
 		default:
 			return nil, errors.New("nbfmt.genDefaultBlock() parse error: invalid statement")
 		}
@@ -809,7 +1337,7 @@ OUTER:
 			switch ctx {
 			case "start":
 				ctx = "else"
-				defBlock, err := genDefaultBlock(ss)
+				defBlock, err := genDefaultBlock(ss, endifstmt)
 				if err != nil {
 					return nil, err
 				}
@@ -834,51 +1362,56 @@ OUTER:
 	return ib, nil
 }
 
-func genSwitchCaseBlock(ss *stmtStack) (*switchcaseBlock, error) {
+func genSwitchCaseBlock(ss *stmtStack, typeSwitch bool) (*switchcaseBlock, error) {
 	scb := &switchcaseBlock{}
 	s := ss.pop()
 	if len(s.idents) < 2 {
 		return nil, fmt.Errorf("nbfmt.genSwitchCaseBlock() parse error: invalid switch case statement (%s)\n", s)
 	}
-	exprIdentList := make([]*ident, 0, 8)
-	exprList := make([]*expression, 0, 8)
-	for _, id := range s.idents[1:] {
-		switch id.typ {
-		case commaIdent:
-			if len(exprIdentList) == 0 {
-				return nil, fmt.Errorf("nbfmt.genSwitchCaseBlock() parse error: invalid switch case statement (%s)\n", s)
-			}
-			expr, err := parseExpression(&exprIdentList, false, false)
-			if err != nil {
-				return nil, err
-			}
-			exprList = append(exprList, expr)
-			exprIdentList = exprIdentList[:0]
-		default:
-			exprIdentList = append(exprIdentList, id)
+	if typeSwitch {
+		types, err := parseTypeList(s.idents[1:])
+		if err != nil {
+			return nil, err
 		}
-	}
-	if len(exprIdentList) > 0 {
-		expr, err := parseExpression(&exprIdentList, false, false)
+		scb.types = types
+	} else {
+		ops, exprList, err := parseCaseList(s.idents[1:])
 		if err != nil {
 			return nil, err
 		}
-		exprList = append(exprList, expr)
+		scb.ops = ops
+		scb.exps = exprList
 	}
-	scb.exps = exprList
 	scb.stmt = s
 	scb.appendSrc(s.src)
 	ctx := "start"
 OUTER:
 	for ss.len() > 0 {
 		switch ss.checkType() {
-		case ifstmt, forstmt, switchstmt, templatestmt, valuestmt:
+		case ifstmt, forstmt, switchstmt, templatestmt, valuestmt, transstmt, breakstmt, continuestmt:
 			subBlock, err := genBlock(ss)
 			if err != nil {
 				return nil, err
 			}
 			scb.appendSubBlock(subBlock)
 			scb.appendSrc(subBlock.getSrc())
+		case fallthroughstmt:
+			if typeSwitch {
+				return nil, fmt.Errorf("nbfmt.genSwitchCaseBlock() parse error: cannot fallthrough in type switch (%s)\n", ss.pop().src)
+			}
+			scb.fallsThrough = true
+			scb.appendSrc(ss.pop().src)
+			// fallthrough must be the last statement of a case, only the text before the next case may follow it
+			for ss.len() > 0 && ss.checkType() == templatestmt && strings.TrimSpace((*ss.stmtList)[0].src) == "" {
+				ss.pop()
+			}
+			if ss.len() > 0 {
+				switch ss.checkType() {
+				case casestmt, defaultstmt, endswitchstmt:
+				default:
+					return nil, fmt.Errorf("nbfmt.genSwitchCaseBlock() parse error: fallthrough is not the last statement of case (%s)\n", s.src)
+				}
+			}
 		case casestmt, defaultstmt, endswitchstmt:
 			ctx = "finish"
 			break OUTER
@@ -889,7 +1422,7 @@ OUTER:
 	if ctx != "finish" {
 		return nil, errors.New("nbfmt.genSwitchCaseBlock() parse error: not finished switchcase block\n")
 	}
-	if len(scb.subBlocks) == 0 {
+	if len(scb.subBlocks) == 0 && !scb.fallsThrough {
 		return nil, errors.New("nbfmt.genSwitchCaseBlock() parse error: empty switchcase block")
 	}
 	return scb, nil
@@ -901,11 +1434,17 @@ func genSwitchBlock(ss *stmtStack) (*switchBlock, error) {
 	if len(s.idents) < 2 {
 		return nil, fmt.Errorf("nbfmt.genSwitchBlock() parse error: invalid switch statement (%s)\n", s)
 	}
-	exprIdentList := s.idents[1:]
-	expr, err := parseExpression(&exprIdentList, false, false)
+	expr, err := parseExpression(s.idents[1:])
 	if err != nil {
 		return nil, err
 	}
+	// switch type(x) is a type switch on x
+	if call, ok := expr.(*callExpr); ok && len(call.args) == 1 {
+		if fn, ok := call.fn.(*varExpr); ok && fn.ident.src == "type" {
+			sb.typeSwitch = true
+			expr = call.args[0]
+		}
+	}
 	sb.exp = expr
 	sb.stmt = s
 	sb.appendSrc(s.src)
@@ -916,7 +1455,7 @@ OUTER:
 		case casestmt:
 			switch ctx {
 			case "start":
-				caseBlock, err := genSwitchCaseBlock(ss)
+				caseBlock, err := genSwitchCaseBlock(ss, sb.typeSwitch)
 				if err != nil {
 					return nil, err
 				}
@@ -929,7 +1468,7 @@ OUTER:
 			switch ctx {
 			case "start":
 				ctx = "default"
-				defBlock, err := genDefaultBlock(ss)
+				defBlock, err := genDefaultBlock(ss, endswitchstmt)
 				if err != nil {
 					return nil, err
 				}
@@ -956,41 +1495,83 @@ OUTER:
 	if len(sb.caseBlocks) == 0 {
 		return nil, errors.New("nbfmt.genSwitchBlock() parse error: empty switch block\n")
 	}
+	if sb.caseBlocks[len(sb.caseBlocks)-1].fallsThrough && sb.defaultBlock == nil {
+		return nil, fmt.Errorf("nbfmt.genSwitchBlock() parse error: cannot fallthrough final case in switch (%s)\n", s.src)
+	}
 	return sb, nil
 }
 
 func genForBlock(ss *stmtStack) (*forBlock, error) {
 	fb := &forBlock{}
 	s := ss.pop()
-	if len(s.idents) < 6 {
+	// for v in expr or for i, v in expr
+	var indexIdent, variableIdent *ident
+	var objExprIdents []*ident
+	switch {
+	case len(s.idents) > 5 && s.idents[2].typ == commaIdent && s.idents[4].typ == inIdent:
+		indexIdent, variableIdent, objExprIdents = s.idents[1], s.idents[3], s.idents[5:]
+		if indexIdent.typ != varIdent {
+			return nil, fmt.Errorf("nbfmt.genForBlock() parse error: invalid for statement (%s)\n", s.src)
+		}
+		fb.indexVarName = indexIdent.src
+	case len(s.idents) > 3 && s.idents[2].typ == inIdent:
+		variableIdent, objExprIdents = s.idents[1], s.idents[3:]
+	default:
 		return nil, fmt.Errorf("nbfmt.genForBlock() parse error: invalid for statement (%s)\n", s.src)
 	}
-	indexIdent := s.idents[1]
-	variableIdent := s.idents[3]
-	objExprIdent := s.idents[5:]
-	if indexIdent.typ != varIdent || variableIdent.typ != varIdent {
+	if variableIdent.typ != varIdent {
 		return nil, fmt.Errorf("nbfmt.genForBlock() parse error: invalid for statement (%s)\n", s.src)
 	}
 	fb.appendSrc(s.src)
 	fb.stmt = s
-	fb.indexVarName = indexIdent.src
 	fb.valueVarName = variableIdent.src
-	objExpr, err := parseExpression(&objExprIdent, false, false)
+	objExpr, modifiers, err := parseExpressionPrefix(objExprIdents)
 	if err != nil {
 		return nil, err
 	}
+	// the order of map can be changed by "desc" and "byvalue" after the expression, "if cond" filters the elements
+MODIFIER:
+	for i, m := range modifiers {
+		switch {
+		case m.typ == varIdent && m.src == "desc":
+			fb.desc = true
+		case m.typ == varIdent && m.src == "byvalue":
+			fb.byValue = true
+		case m.typ == ifIdent:
+			filter, err := parseExpression(modifiers[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			fb.filter = filter
+			break MODIFIER
+		default:
+			return nil, fmt.Errorf("nbfmt.genForBlock() parse error: invalid ident (%s) in for statement (%s)\n", m.src, s.src)
+		}
+	}
 	fb.objExpr = objExpr
 	ctx := "start"
+	ss.loopDepth++
+	defer func() { ss.loopDepth-- }()
 OUTER:
 	for ss.len() > 0 {
 		switch ss.checkType() {
-		case ifstmt, forstmt, switchstmt, templatestmt, valuestmt:
+		case ifstmt, forstmt, switchstmt, templatestmt, valuestmt, transstmt, breakstmt, continuestmt:
 			subBlock, err := genBlock(ss)
 			if err != nil {
 				return nil, err
 			}
 			fb.appendSubBlock(subBlock)
 			fb.appendSrc(subBlock.getSrc())
+		case elsestmt:
+			// break and continue in else block belong to the outer for block
+			ss.loopDepth--
+			elseBlock, err := genDefaultBlock(ss, endforstmt)
+			ss.loopDepth++
+			if err != nil {
+				return nil, err
+			}
+			fb.elseBlock = elseBlock
+			fb.appendSrc(elseBlock.getSrc())
 		case endforstmt:
 			s := ss.pop()
 			fb.appendSrc(s.src)
@@ -1018,7 +1599,7 @@ func genTemplateBlock(ss *stmtStack) (*tempBlock, error) {
 func genValueBlock(ss *stmtStack) (*valueBlock, error) {
 	vb := &valueBlock{}
 	s := ss.pop()
-	expr, err := parseExpression(&s.idents, false, false)
+	expr, err := parseExpression(s.idents)
 	if err != nil {
 		return nil, err
 	}
@@ -1028,6 +1609,39 @@ func genValueBlock(ss *stmtStack) (*valueBlock, error) {
 	return vb, nil
 }
 
+//genTransBlock generates the block of t "key" arg1 arg2..., the key and the arguments are separated by spaces.
+//Each of them is an operand with fields, indexes and calls (e.g. user.Name, l[0] or len(l)), the other expressions
+//must be in parentheses, e.g. t "key" (n + 1)
+func genTransBlock(ss *stmtStack) (*transBlock, error) {
+	s := ss.pop()
+	tb := &transBlock{src: s.src, stmt: s}
+	for i, arg := range s.args {
+		p := newExprParser(arg)
+		// the binding power of dot stops before all the binary operators
+		e, err := p.parse(dotOperator.priority)
+		if err != nil || p.peek() != nil {
+			return nil, fmt.Errorf("nbfmt.genTransBlock() error: invalid argument (%s) in statement (%s), the arguments are separated by spaces and the expressions with operators must be in parentheses", p, s.src)
+		}
+		if i == 0 {
+			tb.key = e
+			continue
+		}
+		tb.args = append(tb.args, e)
+	}
+	return tb, nil
+}
+
+func genLoopCtrlBlock(ss *stmtStack) (*loopCtrlBlock, error) {
+	s := ss.pop()
+	if len(s.idents) != 1 {
+		return nil, fmt.Errorf("nbfmt.genLoopCtrlBlock() parse error: invalid %s statement (%s)\n", s.idents[0].src, s.src)
+	}
+	if ss.loopDepth == 0 {
+		return nil, fmt.Errorf("nbfmt.genLoopCtrlBlock() parse error: %s statement is not in for block (%s)\n", s.idents[0].src, s.src)
+	}
+	return &loopCtrlBlock{src: s.src, stmt: s}, nil
+}
+
 func genBlock(ss *stmtStack) (block, error) {
 	switch ss.checkType() {
 	case ifstmt:
@@ -1040,14 +1654,18 @@ func genBlock(ss *stmtStack) (block, error) {
 		return genTemplateBlock(ss)
 	case valuestmt:
 		return genValueBlock(ss)
+	case transstmt:
+		return genTransBlock(ss)
+	case breakstmt, continuestmt:
+		return genLoopCtrlBlock(ss)
 	default:
 		return nil, fmt.Errorf("nbfmt.genBlock() error: invalid statement (%s)\n", ss.pop().src)
 	}
 }
 
-func genTemplate(sl []*stmt) (template, error) {
+func genTemplate(sl []*stmt) (*Template, error) {
 	ss := newStmtStack(&sl)
-	t := template{}
+	t := &Template{}
 	for ss.len() > 0 {
 		b, err := genBlock(ss)
 		if err != nil {
@@ -0,0 +1,397 @@
+package nbfmt
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//durationUnits are the units of duration literals, d is 24 hours and w is 7 days
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+var durationPartRe = regexp.MustCompile(`(\d+(?:\.\d+)?)(ns|us|ms|s|m|h|d|w)`)
+
+//parseDuration parses the duration literals like 7d, 1h30m and -1.5h
+func parseDuration(s string) (time.Duration, error) {
+	src := s
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	var d time.Duration
+	for _, m := range durationPartRe.FindAllStringSubmatch(s, -1) {
+		n, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("nbfmt.parseDuration() error: invalid duration (%s)", src)
+		}
+		d += time.Duration(n * float64(durationUnits[m[2]]))
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+//operateTime applies the arithmetic operators to times and durations: time ± duration is a time, time - time
+//and duration ± duration are durations, a duration can be multiplied and divided by a number, and duration / duration
+//is a float. ok is false if the operands are not times or durations
+func operateTime(op *operator, lv, rv interface{}) (r interface{}, ok bool, err error) {
+	lt, lIsTime := lv.(time.Time)
+	rt, rIsTime := rv.(time.Time)
+	ld, lIsDuration := lv.(time.Duration)
+	rd, rIsDuration := rv.(time.Duration)
+	if !lIsTime && !rIsTime && !lIsDuration && !rIsDuration {
+		return nil, false, nil
+	}
+	switch {
+	case op == &plugOperator && lIsTime && rIsDuration:
+		return lt.Add(rd), true, nil
+	case op == &plugOperator && lIsDuration && rIsTime:
+		return rt.Add(ld), true, nil
+	case op == &plugOperator && lIsDuration && rIsDuration:
+		return ld + rd, true, nil
+	case op == &subOperator && lIsTime && rIsDuration:
+		return lt.Add(-rd), true, nil
+	case op == &subOperator && lIsTime && rIsTime:
+		return lt.Sub(rt), true, nil
+	case op == &subOperator && lIsDuration && rIsDuration:
+		return ld - rd, true, nil
+	case op == &mulOperator && lIsDuration && isNumberValue(reflect.ValueOf(rv)) && !rIsDuration:
+		return time.Duration(float64(ld) * toFloat(reflect.ValueOf(rv))), true, nil
+	case op == &mulOperator && rIsDuration && isNumberValue(reflect.ValueOf(lv)) && !lIsDuration:
+		return time.Duration(toFloat(reflect.ValueOf(lv)) * float64(rd)), true, nil
+	case op == &divOperator && lIsDuration && isNumberValue(reflect.ValueOf(rv)):
+		f := toFloat(reflect.ValueOf(rv))
+		if f == 0 {
+			return nil, true, fmt.Errorf("nbfmt.operateTime() error: duration divided by zero (%v / %v)", lv, rv)
+		}
+		if rIsDuration {
+			return float64(ld) / f, true, nil
+		}
+		return time.Duration(float64(ld) / f), true, nil
+	case op == &plugOperator, op == &subOperator, op == &mulOperator, op == &divOperator:
+		return nil, true, fmt.Errorf("nbfmt.operateTime() error: invalid operation (%v %s %v) (%T and %T)", lv, op, rv, lv, rv)
+	default:
+		// the comparison operators are not specific to times
+		return nil, false, nil
+	}
+}
+
+//defaultTimeLayout is the layout of the time.Time values rendered by value statements
+const defaultTimeLayout = time.RFC3339
+
+//locations caches the locations loaded by name
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("nbfmt.loadLocation() error: unknown time zone (%s)", name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+//toTime converts a time.Time, a *time.Time or an integer of unix seconds to time.Time
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, fmt.Errorf("nbfmt.toTime() error: nil *time.Time")
+		}
+		return *t, nil
+	}
+	val := reflect.ValueOf(v)
+	switch {
+	case isIntValue(val):
+		return time.Unix(val.Int(), 0), nil
+	case isUintValue(val):
+		return time.Unix(int64(val.Uint()), 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("nbfmt.toTime() error: %v (%T) is not a time", v, v)
+	}
+}
+
+//dateFunc is date(t, layout) or date(t, layout, zone), layout is a Go layout (e.g. "2006-01-02 15:04") or a strftime
+//pattern if it contains % (e.g. "%Y-%m-%d %H:%M"), t is converted to the time zone (e.g. "Asia/Shanghai") if it is given
+func dateFunc(v interface{}, layout string, zone ...string) (string, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return "", err
+	}
+	switch len(zone) {
+	case 0:
+	case 1:
+		if t, err = tzFunc(t, zone[0]); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("nbfmt.dateFunc() error: date takes 2 or 3 arguments (%d supplied)", len(zone)+2)
+	}
+	if strings.Contains(layout, "%") {
+		return strftime(t, layout)
+	}
+	return t.Format(layout), nil
+}
+
+//tzFunc is tz(t, zone), it returns t in the time zone, zone is a name of the IANA database (e.g. "Asia/Shanghai"),
+//"UTC" or "Local"
+func tzFunc(v interface{}, zone string) (time.Time, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := loadLocation(zone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.In(loc), nil
+}
+
+var shortWeekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+//strftime formats t by the strftime pattern
+func strftime(t time.Time, pattern string) (string, error) {
+	builder := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			builder.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		if i == len(pattern) {
+			return "", fmt.Errorf("nbfmt.strftime() error: incomplete directive at the end of pattern (%s)", pattern)
+		}
+		switch pattern[i] {
+		case 'Y':
+			builder.WriteString(strconv.Itoa(t.Year()))
+		case 'y':
+			fmt.Fprintf(&builder, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&builder, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&builder, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&builder, "%2d", t.Day())
+		case 'j':
+			fmt.Fprintf(&builder, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&builder, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&builder, "%02d", (t.Hour()+11)%12+1)
+		case 'l':
+			fmt.Fprintf(&builder, "%2d", (t.Hour()+11)%12+1)
+		case 'M':
+			fmt.Fprintf(&builder, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&builder, "%02d", t.Second())
+		case 'f':
+			fmt.Fprintf(&builder, "%06d", t.Nanosecond()/1000)
+		case 'p':
+			if t.Hour() < 12 {
+				builder.WriteString("AM")
+			} else {
+				builder.WriteString("PM")
+			}
+		case 'b', 'h':
+			builder.WriteString(t.Month().String()[:3])
+		case 'B':
+			builder.WriteString(t.Month().String())
+		case 'a':
+			builder.WriteString(shortWeekdays[t.Weekday()])
+		case 'A':
+			builder.WriteString(t.Weekday().String())
+		case 'u':
+			builder.WriteString(strconv.Itoa((int(t.Weekday())+6)%7 + 1))
+		case 'w':
+			builder.WriteString(strconv.Itoa(int(t.Weekday())))
+		case 'Z':
+			builder.WriteString(t.Format("MST"))
+		case 'z':
+			builder.WriteString(t.Format("-0700"))
+		case 's':
+			builder.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'F':
+			builder.WriteString(t.Format("2006-01-02"))
+		case 'T':
+			builder.WriteString(t.Format("15:04:05"))
+		case 'R':
+			builder.WriteString(t.Format("15:04"))
+		case 'D':
+			builder.WriteString(t.Format("01/02/06"))
+		case 'n':
+			builder.WriteByte('\n')
+		case 't':
+			builder.WriteByte('\t')
+		case '%':
+			builder.WriteByte('%')
+		default:
+			return "", fmt.Errorf("nbfmt.strftime() error: unknown directive (%%%c) in pattern (%s)", pattern[i], pattern)
+		}
+	}
+	return builder.String(), nil
+}
+
+//timeUnit is a unit of the humanized durations
+type timeUnit struct {
+	name string
+	d    time.Duration
+}
+
+var timeUnits = []timeUnit{
+	{"year", 365 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"day", 24 * time.Hour},
+	{"hour", time.Hour},
+	{"minute", time.Minute},
+	{"second", time.Second},
+}
+
+//humanize spells d by at most n of the largest units, e.g. "1 hour 30 minutes", a month is 30 days and a year is 365 days
+func humanize(d time.Duration, n int) string {
+	if d < 0 {
+		d = -d
+	}
+	parts := make([]string, 0, n)
+	for _, u := range timeUnits {
+		if len(parts) == n {
+			break
+		}
+		c := d / u.d
+		if c == 0 {
+			// the units after the largest one are consecutive, e.g. 1 day 5 minutes is 1 day
+			if len(parts) > 0 {
+				break
+			}
+			continue
+		}
+		d -= c * u.d
+		if c == 1 {
+			parts = append(parts, "1 "+u.name)
+		} else {
+			parts = append(parts, fmt.Sprintf("%d %ss", c, u.name))
+		}
+	}
+	if len(parts) == 0 {
+		return "0 seconds"
+	}
+	return strings.Join(parts, " ")
+}
+
+//durationFunc is duration(d) or duration(d, n), it humanizes d by at most n units (2 by default),
+//d is a time.Duration or a number of seconds
+func durationFunc(v interface{}, n ...int64) (string, error) {
+	var d time.Duration
+	switch val := reflect.ValueOf(v); {
+	case val.Kind() == reflect.Invalid:
+		return "", fmt.Errorf("nbfmt.durationFunc() error: nil is not a duration")
+	case val.Type() == reflect.TypeOf(time.Duration(0)):
+		d = v.(time.Duration)
+	case isNumberValue(val):
+		d = time.Duration(toFloat(val) * float64(time.Second))
+	default:
+		return "", fmt.Errorf("nbfmt.durationFunc() error: %v (%T) is not a duration", v, v)
+	}
+	units := int64(2)
+	if len(n) > 0 {
+		units = n[0]
+	}
+	if units < 1 || len(n) > 1 {
+		return "", fmt.Errorf("nbfmt.durationFunc() error: invalid number of units (%v)", n)
+	}
+	return humanize(d, int(units)), nil
+}
+
+//agoFunc is ago(t), it tells how long ago t is from now by the largest unit, e.g. "3 hours ago", "in 2 days" or "just now"
+func agoFunc(sc *scope, v interface{}) (string, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return "", err
+	}
+	d := sc.now().Sub(t)
+	switch {
+	case d > -time.Minute && d < time.Minute:
+		return "just now", nil
+	case d > 0:
+		return humanize(d, 1) + " ago", nil
+	default:
+		return "in " + humanize(d, 1), nil
+	}
+}
+
+//nowFunc is now(), the current time of the clock of the execution
+func nowFunc(sc *scope) time.Time {
+	return sc.now()
+}
+
+//startOfFunc is startOf(t, unit), it returns the beginning of the minute, hour, day, week (Monday), month or year of t
+func startOfFunc(v interface{}, unit string) (time.Time, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	y, mon, d := t.Date()
+	switch unit {
+	case "minute":
+		return time.Date(y, mon, d, t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case "hour":
+		return time.Date(y, mon, d, t.Hour(), 0, 0, 0, t.Location()), nil
+	case "day":
+		return time.Date(y, mon, d, 0, 0, 0, 0, t.Location()), nil
+	case "week":
+		return time.Date(y, mon, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location()), nil
+	case "month":
+		return time.Date(y, mon, 1, 0, 0, 0, 0, t.Location()), nil
+	case "year":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, t.Location()), nil
+	default:
+		return time.Time{}, fmt.Errorf("nbfmt.startOfFunc() error: unknown unit (%s), it must be minute, hour, day, week, month or year", unit)
+	}
+}
+
+//addDateFunc is addDate(t, years, months, days), it adds the calendar years, months and days to t like time.Time.AddDate
+func addDateFunc(v interface{}, years, months, days int) (time.Time, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(years, months, days), nil
+}
+
+//daysFunc is days(from, to), it returns the number of calendar days from the date of from to the date of to,
+//to is converted to the time zone of from
+func daysFunc(from, to interface{}) (int64, error) {
+	f, err := toTime(from)
+	if err != nil {
+		return 0, err
+	}
+	t, err := toTime(to)
+	if err != nil {
+		return 0, err
+	}
+	t = t.In(f.Location())
+	// the dates are compared at UTC so the days changing daylight saving time are 24 hours long
+	fy, fm, fd := f.Date()
+	ty, tm, td := t.Date()
+	diff := time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC).Sub(time.Date(fy, fm, fd, 0, 0, 0, 0, time.UTC))
+	return int64(diff / (24 * time.Hour)), nil
+}
@@ -0,0 +1,119 @@
+package nbfmt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeFuncs(t *testing.T) {
+	created := time.Date(2024, 3, 5, 14, 7, 9, 123456000, time.UTC)
+	env := map[string]interface{}{
+		"created": created,
+		"ptr":     &created,
+		"unix":    created.Unix(),
+		"past":    time.Now().Add(-3*time.Hour - 5*time.Minute),
+		"future":  time.Now().Add(49 * time.Hour),
+		"recent":  time.Now().Add(-10 * time.Second),
+		"elapsed": 90*time.Minute + 20*time.Second,
+		"long":    400*24*time.Hour + 3*time.Hour,
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ created }}`, "2024-03-05T14:07:09Z"},
+		{`{{ elapsed }}`, "1h30m20s"},
+		{`{{ date(created, "2006-01-02 15:04") }}`, "2024-03-05 14:07"},
+		{`{{ date(ptr, "Jan 2, 2006") }}`, "Mar 5, 2024"},
+		{`{{ date(created, "%Y-%m-%d %H:%M:%S") }}`, "2024-03-05 14:07:09"},
+		{`{{ date(created, "%a %b %e %I:%M %p, %j, %%") }}`, "Tue Mar  5 02:07 PM, 065, %"},
+		{`{{ date(created, "%A %B %y %F %T.%f %z") }}`, "Tuesday March 24 2024-03-05 14:07:09.123456 +0000"},
+		{`{{ date(created, "%Y-%m-%d %H:%M %Z", "Asia/Shanghai") }}`, "2024-03-05 22:07 CST"},
+		{`{{ date(unix, "%s") }}`, "1709647629"},
+		{`{{ date(tz(created, "America/New_York"), "15:04 MST") }}`, "09:07 EST"},
+		{`{{ duration(elapsed) }}`, "1 hour 30 minutes"},
+		{`{{ duration(elapsed, 3) }}`, "1 hour 30 minutes 20 seconds"},
+		{`{{ duration(long) }}`, "1 year 1 month"},
+		{`{{ duration(86460, 3) }}`, "1 day"},
+		{`{{ duration(0) }}`, "0 seconds"},
+		{`{{ ago(past) }}`, "3 hours ago"},
+		{`{{ ago(future) }}`, "in 2 days"},
+		{`{{ ago(recent) }}`, "just now"},
+	}
+	for _, test := range tests {
+		got, err := Fmt(test.src, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`{{ date(created, "%Q") }}`,
+		`{{ date(created, "%Y%") }}`,
+		`{{ date(created, "%Y", "Mars/Olympus") }}`,
+		`{{ date("2024", "%Y") }}`,
+		`{{ duration("1h") }}`,
+		`{{ duration(elapsed, 0) }}`,
+	} {
+		if got, err := Fmt(src, env); err == nil {
+			t.Errorf("%s: expected error, got %q", src, got)
+		}
+	}
+}
+
+func TestTimeArithmetic(t *testing.T) {
+	clock := func() time.Time { return time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC) }
+	env := map[string]interface{}{
+		"due":     time.Date(2024, 3, 12, 9, 0, 0, 0, time.UTC),
+		"created": time.Date(2024, 3, 5, 11, 7, 9, 0, time.UTC),
+		"timeout": 90 * time.Second,
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{{ now() }}`, "2024-03-05T14:07:09Z"},
+		{`{{ now() + 7d }}`, "2024-03-12T14:07:09Z"},
+		{`{{ now() - 1h30m }}`, "2024-03-05T12:37:09Z"},
+		{`{{ 2w + now() }}`, "2024-03-19T14:07:09Z"},
+		{`{{ due - now() }}`, "162h52m51s"},
+		{`{{ 1.5h }} {{ -30s }} {{ 100ms * 3 }} {{ timeout * 2 }} {{ 1h / 4 }} {{ 1h / 30m }}`, "1h30m0s -30s 300ms 3m0s 15m0s 2.000000"},
+		{`{{ 1h-30m }}`, "30m0s"},
+		{`{{ if due - now() < 7d }}soon{{ else }}later{{ endif }}`, "soon"},
+		{`{{ if now() > created + 2h }}late{{ endif }}`, "late"},
+		{`{{ ago(created) }}`, "3 hours ago"},
+		{`{{ startOf(now(), "month") }} {{ startOf(now(), "week") }} {{ startOf(now(), "day") }}`, "2024-03-01T00:00:00Z 2024-03-04T00:00:00Z 2024-03-05T00:00:00Z"},
+		{`{{ startOf(now(), "hour") }} {{ startOf(now(), "minute") }} {{ startOf(now(), "year") }}`, "2024-03-05T14:00:00Z 2024-03-05T14:07:00Z 2024-01-01T00:00:00Z"},
+		{`{{ addDate(startOf(now(), "month"), 0, 1, -1) }}`, "2024-03-31T00:00:00Z"},
+		{`{{ days(now(), due) }} {{ days(due, now()) }}`, "7 -7"},
+	}
+	for _, test := range tests {
+		temp, err := Parse(test.src)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		got, err := temp.Execute(env, Clock(clock))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+	for _, src := range []string{
+		`{{ now() + 1 }}`,
+		`{{ 1h / 0 }}`,
+		`{{ 1x }}`,
+		`{{ 1h.5 }}`,
+		`{{ startOf(now(), "decade") }}`,
+	} {
+		if got, err := Fmt(src, env, Clock(clock)); err == nil {
+			t.Errorf("%s: expected error, got %q", src, got)
+		}
+	}
+}
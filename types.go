@@ -1,10 +1,13 @@
 package nbfmt
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type identType int
@@ -47,6 +50,16 @@ const (
 	leftBracketIdent                       // [
 	rightBracketIdent                      // ]
 	nilIdent                               // nil
+	breakIdent                             // break
+	continueIdent                          // continue
+	rangeIdent                             // ..
+	colonIdent                             // :
+	leftBraceIdent                         // {
+	rightBraceIdent                        // }
+	optionalDotIdent                       // ?.
+	optionalBracketIdent                   // ?[
+	fallthroughIdent                       // fallthrough
+	durationIdent                          // 7d, 1h30m
 )
 
 type ident struct {
@@ -58,7 +71,7 @@ func (id *ident) String() string {
 	return id.src
 }
 
-func (id *ident) eval(env map[string]interface{}) (interface{}, error) {
+func (id *ident) eval(sc *scope) (interface{}, error) {
 	switch id.typ {
 	case strIdent:
 		return strings.Trim(id.src, "\""), nil
@@ -68,12 +81,20 @@ func (id *ident) eval(env map[string]interface{}) (interface{}, error) {
 		return strconv.ParseInt(id.src, 10, 64)
 	case floatIdent:
 		return strconv.ParseFloat(id.src, 64)
+	case durationIdent:
+		return parseDuration(id.src)
 	case boolIdent:
 		return strconv.ParseBool(id.src)
 	case varIdent:
-		val, ok := env[id.src]
+		val, ok, err := sc.lookup(id.src)
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
-			return nil, fmt.Errorf("nbfmt.ident.eval() error: %s is not exist in env map", id.src)
+			if f, ok := builtins[id.src]; ok {
+				return f, nil
+			}
+			return sc.missing(&missingError{fmt.Sprintf("nbfmt.ident.eval() error: %s is not exist in env", id.src)})
 		}
 		switch v := val.(type) {
 		case int:
@@ -105,12 +126,18 @@ const (
 	defaultstmt
 	endswitchstmt
 	valuestmt
+	breakstmt
+	continuestmt
+	fallthroughstmt
+	transstmt
 )
 
 type stmt struct {
 	src    string
 	typ    stmtType
 	idents []*ident
+	//args are the idents of the key and the arguments of t statement, each of them is tokenized separately
+	args [][]*ident
 }
 
 func (s *stmt) String() string {
@@ -122,17 +149,160 @@ type block interface {
 	appendSrc(string)
 	appendSubBlock(block)
 	// blow is new edition
-	eval(map[string]interface{}) (string, error)
+	eval(*scope) (string, error)
 }
 
-type template struct {
+//Template is a parsed template, it can be executed many times with different env
+type Template struct {
 	blocks []block
+	opts   options
+}
+
+//scope holds the variables and the options of an execution. The scopes of nested blocks are chained by parent,
+//a name is looked up from the innermost scope to the root, so blocks bind variables without copying the outer ones
+type scope struct {
+	parent *scope
+	//names and vals are the variables bound in the scope, a for block binds at most 3 so they are searched linearly
+	names []string
+	vals  []interface{}
+	//vars and root are the data of the execution, they are set in the root scope only,
+	//the names which are not in vars are resolved against root (the fields and methods of struct or the values of map)
+	vars map[string]interface{}
+	root interface{}
+	opts *options
+}
+
+func newScope(vars map[string]interface{}, opts *options) *scope {
+	return &scope{vars: vars, opts: opts}
+}
+
+//newRootScope returns the scope for the root data of an execution, map[string]interface{} is used as vars directly
+func newRootScope(data interface{}, opts *options) (*scope, error) {
+	if vars, ok := data.(map[string]interface{}); ok {
+		return newScope(vars, opts), nil
+	}
+	sc := newScope(nil, opts)
+	val := reflect.ValueOf(data)
+	for val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+	switch {
+	case data == nil:
+	case val.Kind() == reflect.Struct, val.Kind() == reflect.Ptr && val.Type().Elem().Kind() == reflect.Struct:
+		sc.root = data
+	case val.Kind() == reflect.Map && (val.Type().Key().Kind() == reflect.String || val.Type().Key().Kind() == reflect.Interface):
+		sc.root = data
+	default:
+		return nil, fmt.Errorf("nbfmt.newRootScope() error: the data must be struct, pointer to struct or map with string keys (%T)", data)
+	}
+	return sc, nil
+}
+
+//lookup returns the variable by name, sc can be nil when the literals are evaluated in parsing. ok is false if
+//the name is not a variable, err is the error of reading the field of the root data (e.g. an unexported field)
+func (sc *scope) lookup(name string) (v interface{}, ok bool, err error) {
+	for s := sc; s != nil; s = s.parent {
+		for i, n := range s.names {
+			if n == name {
+				return s.vals[i], true, nil
+			}
+		}
+		if s.parent != nil {
+			continue
+		}
+		if v, ok := s.vars[name]; ok {
+			return v, true, nil
+		}
+		if s.root != nil {
+			v, err := field(s.root, &ident{src: name, typ: varIdent}, s.opts)
+			switch err.(type) {
+			case nil:
+				return v, true, nil
+			case *noFieldError, *missingError:
+			default:
+				return nil, false, err
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+//set binds name to v in sc, it shadows the variable with the same name in the outer scopes
+func (sc *scope) set(name string, v interface{}) {
+	for i, n := range sc.names {
+		if n == name {
+			sc.vals[i] = v
+			return
+		}
+	}
+	sc.names = append(sc.names, name)
+	sc.vals = append(sc.vals, v)
+}
+
+//child returns a new scope for the variables of a nested block
+func (sc *scope) child() *scope {
+	return &scope{parent: sc, opts: sc.opts}
+}
+
+//missingError is the error of a variable which is not in env or a key which is not in map
+type missingError struct {
+	msg string
 }
 
-func (t template) eval(env map[string]interface{}) (string, error) {
+func (e *missingError) Error() string {
+	return e.msg
+}
+
+//noFieldError is the error of a name which is neither a field nor a method of struct
+type noFieldError struct {
+	msg string
+}
+
+func (e *noFieldError) Error() string {
+	return e.msg
+}
+
+//isMissing reports whether err is caused by a missing variable or key
+func isMissing(err error) bool {
+	var me *missingError
+	return errors.As(err, &me)
+}
+
+//missing returns the value of a missing variable or key by the Missing option, err is returned if it is MissingError or MissingKeep
+func (sc *scope) missing(err *missingError) (interface{}, error) {
+	if sc != nil && sc.opts != nil {
+		switch sc.opts.missing {
+		case MissingZero:
+			return nil, nil
+		case MissingEmpty:
+			return "", nil
+		}
+	}
+	return nil, err
+}
+
+//strict returns a child scope in which the missing variables and keys are errors whatever the Missing option is
+func (sc *scope) strict() *scope {
+	var opts options
+	if sc.opts != nil {
+		opts = *sc.opts
+	}
+	opts.missing = MissingError
+	return &scope{parent: sc, opts: &opts}
+}
+
+//now is the current time used by the time functions, it is read from the Clock option if it is given
+func (sc *scope) now() time.Time {
+	if sc.opts != nil && sc.opts.clock != nil {
+		return sc.opts.clock()
+	}
+	return time.Now()
+}
+
+func (t *Template) eval(sc *scope) (string, error) {
 	builder := strings.Builder{}
 	for _, b := range t.blocks {
-		s, err := b.eval(env)
+		s, err := b.eval(sc)
 		if err != nil {
 			return "", err
 		}
@@ -141,6 +311,24 @@ func (t template) eval(env map[string]interface{}) (string, error) {
 	return builder.String(), nil
 }
 
+//errBreak and errContinue are returned by break and continue statements, they are passed up to the nearest for block
+var errBreak = errors.New("nbfmt: break statement is not in for block")
+var errContinue = errors.New("nbfmt: continue statement is not in for block")
+
+//evalBlocks evaluates blocks in order, when a block fails the output before it is returned together with the error,
+//so the output before break and continue statements is kept
+func evalBlocks(l []block, sc *scope) (string, error) {
+	builder := strings.Builder{}
+	for _, b := range l {
+		s, err := b.eval(sc)
+		builder.WriteString(s)
+		if err != nil {
+			return builder.String(), err
+		}
+	}
+	return builder.String(), nil
+}
+
 type tempBlock struct {
 	src string
 }
@@ -153,23 +341,22 @@ func (b *tempBlock) appendSrc(s string) {
 	b.src += s
 }
 
-func (b *tempBlock) run(env map[string]interface{}) (string, error) {
+func (b *tempBlock) run(sc *scope) (string, error) {
 	return b.src, nil
 }
 
 func (b *tempBlock) appendSubBlock(blk block) {}
 
-func (b *tempBlock) eval(env map[string]interface{}) (string, error) {
+func (b *tempBlock) eval(sc *scope) (string, error) {
 	return b.src, nil
 }
 
 type ifcaseBlock struct {
 	src       string
 	subBlocks []block
-	exprObj   *expression
 	//blow is new edition
 	stmt *stmt
-	exp  *expression
+	exp  expression
 }
 
 func (b *ifcaseBlock) getSrc() string {
@@ -184,27 +371,25 @@ func (b *ifcaseBlock) appendSubBlock(blk block) {
 	b.subBlocks = append(b.subBlocks, blk)
 }
 
-func (b *ifcaseBlock) eval(env map[string]interface{}) (string, error) {
-	expVal, err := b.exp.copy().eval(env)
+//match evaluates the condition of the case, it must be bool
+func (b *ifcaseBlock) match(sc *scope) (bool, error) {
+	expVal, err := b.exp.eval(sc)
 	if err != nil {
-		return "", err
+		return false, err
 	}
-	if isMatch, ok := expVal.(bool); !ok {
-		return "", fmt.Errorf("nbfmt.ifcaseBlock.eval() error: the type of expression in if case block must be bool (%v)\n", b.exp)
-	} else {
-		builder := strings.Builder{}
-		if isMatch {
-			for _, sb := range b.subBlocks {
-				s, err := sb.eval(env)
-				if err != nil {
-					return "", err
-				}
-				builder.WriteString(s)
-			}
-			return builder.String(), nil
-		}
-		return "", nil
+	isMatch, ok := expVal.(bool)
+	if !ok {
+		return false, fmt.Errorf("nbfmt.ifcaseBlock.eval() error: the type of expression in if case block must be bool (%v)\n", b.exp)
+	}
+	return isMatch, nil
+}
+
+func (b *ifcaseBlock) eval(sc *scope) (string, error) {
+	isMatch, err := b.match(sc)
+	if err != nil || !isMatch {
+		return "", err
 	}
+	return evalBlocks(b.subBlocks, sc)
 }
 
 type defaultBlock struct {
@@ -225,16 +410,8 @@ func (b *defaultBlock) appendSubBlock(blk block) {
 	b.subBlocks = append(b.subBlocks, blk)
 }
 
-func (b *defaultBlock) eval(env map[string]interface{}) (string, error) {
-	builder := strings.Builder{}
-	for _, sb := range b.subBlocks {
-		s, err := sb.eval(env)
-		if err != nil {
-			return "", err
-		}
-		builder.WriteString(s)
-	}
-	return builder.String(), nil
+func (b *defaultBlock) eval(sc *scope) (string, error) {
+	return evalBlocks(b.subBlocks, sc)
 }
 
 type ifBlock struct {
@@ -258,9 +435,9 @@ func (b *ifBlock) appendSubBlock(blk block) {
 	b.subBlocks = append(b.subBlocks, blk)
 }
 
-func (b *ifBlock) run(env map[string]interface{}) (string, error) {
+func (b *ifBlock) run(sc *scope) (string, error) {
 	for _, sb := range b.subBlocks {
-		s, err := sb.eval(env)
+		s, err := sb.eval(sc)
 		if err != nil {
 			return "", err
 		}
@@ -271,22 +448,19 @@ func (b *ifBlock) run(env map[string]interface{}) (string, error) {
 	return "", nil
 }
 
-func (b *ifBlock) eval(env map[string]interface{}) (string, error) {
-	for _, sb := range b.caseBlocks {
-		s, err := sb.eval(env)
+func (b *ifBlock) eval(sc *scope) (string, error) {
+	// the first case whose condition is true is rendered, even if its output is empty
+	for _, cb := range b.caseBlocks {
+		isMatch, err := cb.match(sc)
 		if err != nil {
 			return "", err
 		}
-		if s != "" {
-			return s, nil
+		if isMatch {
+			return evalBlocks(cb.subBlocks, sc)
 		}
 	}
 	if b.defaultBlock != nil {
-		s, err := b.defaultBlock.eval(env)
-		if err != nil {
-			return "", err
-		}
-		return s, nil
+		return b.defaultBlock.eval(sc)
 	}
 	return "", nil
 }
@@ -297,8 +471,14 @@ type forBlock struct {
 	indexVarName string
 	valueVarName string
 	//blow is new edition
-	stmt    *stmt
-	objExpr *expression
+	stmt      *stmt
+	objExpr   expression
+	elseBlock *defaultBlock
+	//desc and byValue change the order of map iteration, maps are iterated by ascending keys by default
+	desc    bool
+	byValue bool
+	//filter skips the elements for which it is false, the index of slice counts only the kept elements
+	filter expression
 }
 
 func (b *forBlock) getSrc() string {
@@ -313,56 +493,194 @@ func (b *forBlock) appendSubBlock(blk block) {
 	b.subBlocks = append(b.subBlocks, blk)
 }
 
-func (b *forBlock) eval(env map[string]interface{}) (string, error) {
-	localEnv := make(map[string]interface{})
-	for k, v := range env {
-		localEnv[k] = v
-	}
-	iterObj, err := b.objExpr.copy().eval(env)
+func (b *forBlock) eval(sc *scope) (string, error) {
+	localEnv := sc.child()
+	iterObj, err := b.objExpr.eval(sc)
 	if err != nil {
 		return "", err
 	}
-	builder := strings.Builder{}
-	iterObjVal := reflect.ValueOf(iterObj)
-	switch iterObjVal.Kind() {
-	case reflect.Slice, reflect.Array:
-		for i := 0; i < iterObjVal.Len(); i++ {
-			localEnv[b.indexVarName] = int64(i)
-			localEnv[b.valueVarName] = iterObjVal.Index(i).Interface()
-			for _, sb := range b.subBlocks {
-				s, err := sb.eval(localEnv)
-				if err != nil {
-					return "", err
+	if b.desc || b.byValue || reflect.ValueOf(iterObj).Kind() == reflect.Map {
+		sorted, ok := sortMap(iterObj, b.desc, b.byValue)
+		if !ok {
+			return "", fmt.Errorf("nbfmt.forBlock.eval() error: desc and byvalue can only be used for map (%s)\n", b.objExpr.String())
+		}
+		iterObj = sorted
+	}
+	length, ok := iterLen(iterObj)
+	if !ok {
+		return "", fmt.Errorf("nbfmt.forBlock.eval() error: the object for iterating (%T) is not iterable (%s)\n", iterObj, b.objExpr.String())
+	}
+	keyed := isKeyed(iterObj)
+	// the kept elements of finite objects are collected before rendering, so loop.length is still known
+	if b.filter != nil && length >= 0 {
+		var kept MapSlice
+		var filterErr error
+		iterErr := each(iterObj, func(key, val interface{}) bool {
+			var ok bool
+			if ok, filterErr = b.match(localEnv, key, val); filterErr != nil {
+				return false
+			}
+			if ok {
+				if !keyed {
+					key = int64(len(kept))
 				}
-				builder.WriteString(s)
+				kept = append(kept, MapItem{key, val})
 			}
+			return true
+		})
+		if iterErr != nil {
+			return "", iterErr
 		}
-		return builder.String(), nil
-	case reflect.Map:
-		keys := iterObjVal.MapKeys()
-		for _, key := range keys {
-			localEnv[b.indexVarName] = key.Interface()
-			localEnv[b.valueVarName] = iterObjVal.MapIndex(key).Interface()
-			for _, sb := range b.subBlocks {
-				s, err := sb.eval(localEnv)
-				if err != nil {
-					return "", err
-				}
-				builder.WriteString(s)
+		if filterErr != nil {
+			return "", filterErr
+		}
+		iterObj, length, keyed = kept, int64(len(kept)), true
+	}
+	loop, _, _ := sc.lookup("loop")
+	parent, _ := loop.(*loopVar)
+	builder := strings.Builder{}
+	var i int64
+	// an element is rendered after the next element is received, so loop.last is known for channels and iterators
+	var pending *MapItem
+	var stop bool
+	render := func(key, val interface{}, last bool) {
+		if b.indexVarName != "" {
+			localEnv.set(b.indexVarName, key)
+		}
+		localEnv.set(b.valueVarName, val)
+		localEnv.set("loop", &loopVar{index: i, length: length, last: last, parent: parent})
+		i++
+		var s string
+		s, err = evalBlocks(b.subBlocks, localEnv)
+		builder.WriteString(s)
+		switch err {
+		case errContinue:
+			err = nil
+		case errBreak:
+			err = nil
+			stop = true
+		case nil:
+		default:
+			stop = true
+		}
+	}
+	// the elements of channels and iterators are filtered while they are received
+	var kept int64
+	iterErr := each(iterObj, func(key, val interface{}) bool {
+		if b.filter != nil && length < 0 {
+			ok, filterErr := b.match(localEnv, key, val)
+			if filterErr != nil {
+				err = filterErr
+				return false
+			}
+			if !ok {
+				return true
+			}
+			if !keyed {
+				key = kept
+			}
+			kept++
+		}
+		if pending != nil {
+			render(pending.Key, pending.Value, false)
+			if stop {
+				return false
 			}
 		}
-		return builder.String(), nil
+		pending = &MapItem{key, val}
+		return true
+	})
+	if iterErr != nil {
+		return "", iterErr
+	}
+	if pending != nil && !stop && err == nil {
+		render(pending.Key, pending.Value, true)
+	}
+	if err != nil {
+		return "", err
+	}
+	if i == 0 && b.elseBlock != nil {
+		return b.elseBlock.eval(sc)
+	}
+	return builder.String(), nil
+}
+
+//match reports whether the element passes the filter of for block
+func (b *forBlock) match(sc *scope, key, val interface{}) (bool, error) {
+	if b.indexVarName != "" {
+		sc.set(b.indexVarName, key)
+	}
+	sc.set(b.valueVarName, val)
+	ok, err := b.filter.eval(sc)
+	if err != nil {
+		return false, err
+	}
+	if ok, isBool := ok.(bool); isBool {
+		return ok, nil
+	}
+	return false, fmt.Errorf("nbfmt.forBlock.match() error: the result of filter (%s) is not bool (%T)\n", b.filter.String(), ok)
+}
+
+//loopVar is the value of "loop" variable in for block, it describes the current iteration
+type loopVar struct {
+	index int64
+	//length is -1 if the number of elements is unknown (channel and iterator)
+	length int64
+	last   bool
+	parent *loopVar
+}
+
+func (l *loopVar) field(name string) (interface{}, error) {
+	switch name {
+	case "index":
+		return l.index, nil
+	case "index1":
+		return l.index + 1, nil
+	case "revindex", "length":
+		if l.length < 0 {
+			return nil, fmt.Errorf("nbfmt.loopVar.field() error: loop.%s is not available because the number of elements is unknown", name)
+		}
+		if name == "length" {
+			return l.length, nil
+		}
+		return l.length - l.index - 1, nil
+	case "first":
+		return l.index == 0, nil
+	case "last":
+		return l.last, nil
+	case "parent":
+		if l.parent == nil {
+			return nil, nil
+		}
+		return l.parent, nil
+	case "cycle":
+		return l.cycle, nil
 	default:
-		return "", fmt.Errorf("nbfmt.forBlock.eval() the object for iterating is not a array(slice) or a map (%s)\n", b.objExpr.String())
+		return nil, fmt.Errorf("nbfmt.loopVar.field() error: loop has no field %s", name)
 	}
 }
 
+//cycle returns the elements of l by turns
+func (l *loopVar) cycle(vals ...interface{}) (interface{}, error) {
+	if len(vals) == 0 {
+		return nil, errors.New("nbfmt.loopVar.cycle() error: no value to cycle")
+	}
+	return vals[l.index%int64(len(vals))], nil
+}
+
 type switchcaseBlock struct {
 	src       string
 	subBlocks []block
 	//blow is new edition
-	exps []*expression
-	stmt *stmt
+	exps []expression
+	//ops are the comparison operators of the predicate cases (e.g. case > 100), nil means the case expression
+	//is compared with the target by ==
+	ops []*operator
+	//types are the type names of the cases of a type switch
+	types []string
+	//fallsThrough is true if the case ends with a fallthrough statement
+	fallsThrough bool
+	stmt         *stmt
 }
 
 func (b *switchcaseBlock) getSrc() string {
@@ -381,26 +699,70 @@ func (b *switchcaseBlock) init() error {
 	return nil
 }
 
-func (b *switchcaseBlock) eval(env map[string]interface{}) (string, error) {
-	tarVal := env["_targetVal"]
-	for _, e := range b.exps {
-		expVal, err := e.copy().eval(env)
+//match reports whether one of the case expressions equals tarVal (or one of the predicates is true for tarVal),
+//in a type switch it reports whether tarVal is of one of the case types
+func (b *switchcaseBlock) match(sc *scope, tarVal interface{}) (bool, error) {
+	for _, t := range b.types {
+		if isType(tarVal, t) {
+			return true, nil
+		}
+	}
+	for i, e := range b.exps {
+		expVal, err := e.eval(sc)
 		if err != nil {
-			return "", err
+			return false, err
 		}
-		if tarVal == expVal {
-			builder := strings.Builder{}
-			for _, sb := range b.subBlocks {
-				s, err := sb.eval(env)
-				if err != nil {
-					return "", err
-				}
-				builder.WriteString(s)
-			}
-			return builder.String(), nil
+		op := b.ops[i]
+		if op == nil {
+			op = &equalOperator
+		}
+		r, err := operate(op, tarVal, expVal)
+		if err != nil {
+			return false, err
+		}
+		ok, isBool := r.(bool)
+		if !isBool {
+			return false, fmt.Errorf("nbfmt.switchcaseBlock.match() error: the result of case (%s %s) is not bool", op, e)
+		}
+		if ok {
+			return true, nil
 		}
 	}
-	return "", nil
+	return false, nil
+}
+
+//isType reports whether v is of the type named name, the names of categories (nil, bool, string, int, float, number,
+//list, map, struct and func) match all the types of the category, e.g. int matches int64 and uint8,
+//other names are compared with the name of the type of v (e.g. time.Time, []string)
+func isType(v interface{}, name string) bool {
+	if v == nil {
+		return name == "nil"
+	}
+	typ := reflect.TypeOf(v)
+	switch name {
+	case "nil":
+		return false
+	case "bool":
+		return typ.Kind() == reflect.Bool
+	case "string":
+		return typ.Kind() == reflect.String
+	case "int":
+		val := reflect.ValueOf(v)
+		return isIntValue(val) || isUintValue(val)
+	case "float":
+		return typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64
+	case "number":
+		return isNumberValue(reflect.ValueOf(v))
+	case "list":
+		return typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array
+	case "map":
+		return typ.Kind() == reflect.Map || typ == reflect.TypeOf(MapSlice(nil))
+	case "struct":
+		return typ.Kind() == reflect.Struct || (typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Struct)
+	case "func":
+		return typ.Kind() == reflect.Func
+	}
+	return strings.Replace(typ.String(), " ", "", -1) == name
 }
 
 type switchBlock struct {
@@ -409,8 +771,10 @@ type switchBlock struct {
 	//blow is new edition
 	caseBlocks   []*switchcaseBlock
 	defaultBlock *defaultBlock
-	exp          *expression
-	stmt         *stmt
+	exp          expression
+	//typeSwitch is true for switch type(x), then the cases are type names
+	typeSwitch bool
+	stmt       *stmt
 }
 
 func (b *switchBlock) getSrc() string {
@@ -425,35 +789,65 @@ func (b *switchBlock) appendSubBlock(blk block) {
 	b.subBlocks = append(b.subBlocks, blk)
 }
 
-func (b *switchBlock) eval(env map[string]interface{}) (string, error) {
-	localEnv := make(map[string]interface{})
-	for k, v := range env {
-		localEnv[k] = v
-	}
-	tarVal, err := b.exp.copy().eval(env)
+func (b *switchBlock) eval(sc *scope) (string, error) {
+	tarVal, err := b.exp.eval(sc)
 	if err != nil {
 		return "", err
 	}
-	localEnv["_targetVal"] = tarVal
-	for _, cb := range b.caseBlocks {
-		s, err := cb.eval(localEnv)
+	for i, cb := range b.caseBlocks {
+		ok, err := cb.match(sc, tarVal)
 		if err != nil {
 			return "", err
 		}
-		if s != "" {
-			return s, nil
+		if !ok {
+			continue
+		}
+		// fallthrough goes on with the body of the next case (or the default block) without matching it
+		var builder strings.Builder
+		for ; i < len(b.caseBlocks); i++ {
+			s, err := evalBlocks(b.caseBlocks[i].subBlocks, sc)
+			builder.WriteString(s)
+			if err != nil || !b.caseBlocks[i].fallsThrough {
+				return builder.String(), err
+			}
 		}
+		s, err := b.defaultBlock.eval(sc)
+		builder.WriteString(s)
+		return builder.String(), err
 	}
 	if b.defaultBlock != nil {
-		return b.defaultBlock.eval(localEnv)
+		return b.defaultBlock.eval(sc)
 	}
 	return "", nil
 }
 
+//loopCtrlBlock is a break or continue statement
+type loopCtrlBlock struct {
+	src  string
+	stmt *stmt
+}
+
+func (b *loopCtrlBlock) getSrc() string {
+	return b.src
+}
+
+func (b *loopCtrlBlock) appendSrc(s string) {
+	b.src += s
+}
+
+func (b *loopCtrlBlock) appendSubBlock(blk block) {}
+
+func (b *loopCtrlBlock) eval(sc *scope) (string, error) {
+	if b.stmt.typ == breakstmt {
+		return "", errBreak
+	}
+	return "", errContinue
+}
+
 type valueBlock struct {
 	src string
 	//blow is new edition
-	exp  *expression
+	exp  expression
 	stmt *stmt
 }
 
@@ -467,35 +861,89 @@ func (b *valueBlock) appendSrc(s string) {
 
 func (b *valueBlock) appendSubBlock(blk block) {}
 
-func (b *valueBlock) eval(env map[string]interface{}) (string, error) {
-	expVal, err := b.exp.copy().eval(env)
+func (b *valueBlock) eval(sc *scope) (string, error) {
+	expVal, err := b.exp.eval(sc)
 	if err != nil {
+		if sc.opts != nil && sc.opts.missing == MissingKeep && isMissing(err) {
+			return b.src, nil
+		}
 		return "", err
 	}
-	switch val := expVal.(type) {
+	s, ok := formatValue(expVal)
+	if !ok {
+		return "", fmt.Errorf("nbfmt.valueBlock.eval() error: unsupported value block type (%s)", b.exp.String())
+	}
+	return s, nil
+}
+
+//formatValue converts the value to the text which is rendered, ok is false if the type of value is not supported
+func formatValue(v interface{}) (string, bool) {
+	switch val := v.(type) {
 	case string:
-		return val, nil
+		return val, true
 	case byte:
-		return fmt.Sprintf("%c", val), nil
+		return fmt.Sprintf("%c", val), true
 	case int, int8, int16, int32, int64, uint, uint16, uint32, uint64:
-		return fmt.Sprintf("%d", val), nil
+		return fmt.Sprintf("%d", val), true
 	case float32, float64:
-		return fmt.Sprintf("%f", val), nil
+		return fmt.Sprintf("%f", val), true
 	case bool:
-		return fmt.Sprintf("%t", val), nil
+		return fmt.Sprintf("%t", val), true
+	case time.Time:
+		return val.Format(defaultTimeLayout), true
+	case time.Duration:
+		return val.String(), true
 	case nil:
-		return "nil", nil
+		return "nil", true
 	default:
-		return "", fmt.Errorf("nbfmt.valueBlock.eval() error: unsupported value block type (%s)", b.exp.String())
+		return "", false
+	}
+}
+
+//transBlock is a t statement, it renders the translation of key with the arguments
+type transBlock struct {
+	src  string
+	key  expression
+	args []expression
+	stmt *stmt
+}
+
+func (b *transBlock) getSrc() string {
+	return b.src
+}
+
+func (b *transBlock) appendSrc(s string) {
+	b.src += s
+}
+
+func (b *transBlock) appendSubBlock(blk block) {}
+
+func (b *transBlock) eval(sc *scope) (string, error) {
+	kv, err := b.key.eval(sc)
+	if err != nil {
+		return "", err
+	}
+	key, ok := kv.(string)
+	if !ok {
+		return "", fmt.Errorf("nbfmt.transBlock.eval() error: the key of t statement is not a string (%s)", b.stmt.src)
+	}
+	args := make([]interface{}, len(b.args))
+	for i, e := range b.args {
+		if args[i], err = e.eval(sc); err != nil {
+			return "", err
+		}
 	}
+	return translate(sc.opts, key, args)
 }
 
 type stmtStack struct {
 	stmtList *[]*stmt
+	//loopDepth is the number of for blocks which contain the current statement
+	loopDepth int
 }
 
 func newStmtStack(l *[]*stmt) *stmtStack {
-	return &stmtStack{l}
+	return &stmtStack{stmtList: l}
 }
 
 func (ss *stmtStack) pop() *stmt {
@@ -532,6 +980,7 @@ var indexOperator = operator{"[]", 7}
 var dotOperator = operator{".", 7}
 var derefOperator = operator{"*", 6}
 var notOperator = operator{"!", 6}
+var negOperator = operator{"-", 6}
 var mulOperator = operator{"*", 5}
 var divOperator = operator{"/", 5}
 var plugOperator = operator{"+", 4}
@@ -542,112 +991,487 @@ var lessThanOperator = operator{"<", 3}
 var lessThanEqualOperator = operator{"<=", 3}
 var greatThanOperator = operator{">", 3}
 var greatThanEqualOperator = operator{">=", 3}
+var rangeOperator = operator{"..", 3}
+var inOperator = operator{"in", 3}
 var andOperator = operator{"&&", 2}
 var orOperator = operator{"||", 1}
 
-type expression struct {
-	subExpr  *expression
-	parExpr  *expression
-	nextExpr *expression
-	prevExpr *expression
-	ident    *ident
+//expression is a node of the expression tree, it is immutable after parsing so it can be evaluated any times
+type expression interface {
+	eval(sc *scope) (interface{}, error)
+	String() string
+}
+
+//literalExpr is a constant, its value is computed once at parse time
+type literalExpr struct {
+	ident *ident
+	value interface{}
+}
+
+func (e *literalExpr) String() string {
+	return e.ident.src
+}
+
+func (e *literalExpr) eval(sc *scope) (interface{}, error) {
+	return e.value, nil
+}
+
+type varExpr struct {
+	ident *ident
+}
+
+func (e *varExpr) String() string {
+	return e.ident.src
+}
+
+func (e *varExpr) eval(sc *scope) (interface{}, error) {
+	return e.ident.eval(sc)
+}
+
+type unaryExpr struct {
 	operator *operator
-	value    interface{}
-}
-
-func (e *expression) String() string {
-	var identStr, operatorStr string
-	if e.ident != nil {
-		identStr = e.ident.String()
-	} else {
-		identStr = "nil"
-	}
-	if e.operator != nil {
-		operatorStr = e.operator.String()
-	} else {
-		operatorStr = "nil"
-	}
-	return fmt.Sprintf(`
-ident: %s
-operator: %s`, identStr, operatorStr)
-}
-
-func (e *expression) pop() *expression {
-	priExpr := e
-	curExpr := e
-	nxtExpr := e.nextExpr
-	for nxtExpr != nil {
-		if nxtExpr.operator != nil {
-			if nxtExpr.operator.priority > curExpr.operator.priority {
-				priExpr = nxtExpr
-				curExpr = nxtExpr
-				nxtExpr = nxtExpr.nextExpr
-			} else {
-				curExpr = nxtExpr
-				nxtExpr = nxtExpr.nextExpr
-			}
-		} else {
-			break
-		}
-	}
-	if priExpr.subExpr != nil && priExpr.subExpr.value == nil {
-		return priExpr.subExpr.pop()
-	}
-	if priExpr.operator == &indexOperator && priExpr.nextExpr.value == nil {
-		return priExpr.nextExpr.pop()
-	}
-	return priExpr
+	operand  expression
 }
 
-func assertToInt(lv, rv interface{}) (int64, int64, bool) {
-	ilv, ok := lv.(int64)
-	if !ok {
-		return 0, 0, false
-	}
-	irv, ok := rv.(int64)
-	if !ok {
-		return 0, 0, false
-	}
-	return ilv, irv, true
+func (e *unaryExpr) String() string {
+	return e.operator.src + e.operand.String()
 }
 
-func assertToFloat(lv, rv interface{}) (float64, float64, bool) {
-	flv, ok := lv.(float64)
-	if !ok {
-		return 0, 0, false
+func (e *unaryExpr) eval(sc *scope) (interface{}, error) {
+	v, err := e.operand.eval(sc)
+	if err != nil {
+		return nil, err
 	}
-	frv, ok := rv.(float64)
-	if !ok {
-		return 0, 0, false
+	switch e.operator {
+	case &notOperator:
+		return not(v)
+	case &derefOperator:
+		return deref(v)
+	case &negOperator:
+		return neg(v)
+	default:
+		return nil, fmt.Errorf("nbfmt.unaryExpr.eval() error: invalid unary operator (%s)", e.operator)
 	}
-	return flv, frv, true
 }
 
-func assertToStr(lv, rv interface{}) (string, string, bool) {
-	slv, ok := lv.(string)
-	if !ok {
-		return "", "", false
-	}
-	srv, ok := rv.(string)
-	if !ok {
-		return "", "", false
-	}
-	return slv, srv, true
+type binaryExpr struct {
+	operator *operator
+	left     expression
+	right    expression
 }
 
-func assertToBool(lv, rv interface{}) (bool, bool, bool) {
-	blv, ok := lv.(bool)
-	if !ok {
-		return false, false, false
+func (e *binaryExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.left, e.operator, e.right)
+}
+
+func (e *binaryExpr) eval(sc *scope) (interface{}, error) {
+	lv, err := e.left.eval(sc)
+	if err != nil {
+		return nil, err
 	}
-	brv, ok := rv.(bool)
-	if !ok {
-		return false, false, false
+	// && and || do not evaluate the right side when the left side has decided the result
+	if b, ok := lv.(bool); ok {
+		if (e.operator == &andOperator && !b) || (e.operator == &orOperator && b) {
+			return b, nil
+		}
 	}
-	return blv, brv, true
+	rv, err := e.right.eval(sc)
+	if err != nil {
+		return nil, err
+	}
+	return operate(e.operator, lv, rv)
 }
 
-func assertToByte(lv, rv interface{}) (byte, byte, bool) {
+//operate applies the binary operator op to lv and rv
+func operate(op *operator, lv, rv interface{}) (interface{}, error) {
+	if r, ok, err := operateTime(op, lv, rv); ok {
+		return r, err
+	}
+	switch op {
+	case &plugOperator:
+		return add(lv, rv)
+	case &subOperator:
+		return sub(lv, rv)
+	case &mulOperator:
+		return mul(lv, rv)
+	case &divOperator:
+		return div(lv, rv)
+	case &equalOperator:
+		return equal(lv, rv)
+	case &notEqualOperator:
+		return notEqual(lv, rv)
+	case &lessThanOperator:
+		return lessThan(lv, rv)
+	case &lessThanEqualOperator:
+		return lessThanEqual(lv, rv)
+	case &greatThanOperator:
+		return greatThan(lv, rv)
+	case &greatThanEqualOperator:
+		return greatThanEqual(lv, rv)
+	case &andOperator:
+		return and(lv, rv)
+	case &orOperator:
+		return or(lv, rv)
+	case &rangeOperator:
+		return closedRange(lv, rv)
+	case &inOperator:
+		return contains(rv, lv)
+	default:
+		return nil, fmt.Errorf("nbfmt.operate() error: invalid binary operator (%s)", op)
+	}
+}
+
+//chainExpr is implemented by the links of a field and index chain (e.g. a?.b[0].c), evalChain reports absent
+//if an optional link has met nil or a missing field, then the rest of the chain is skipped and the result is nil
+type chainExpr interface {
+	evalChain(sc *scope) (v interface{}, absent bool, err error)
+}
+
+//evalObj evaluates the object of a link in the chain
+func evalObj(e expression, sc *scope) (interface{}, bool, error) {
+	if c, ok := e.(chainExpr); ok {
+		return c.evalChain(sc)
+	}
+	v, err := e.eval(sc)
+	return v, false, err
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return val.IsNil()
+	default:
+		return false
+	}
+}
+
+//dotExpr is obj.field, or obj?.field if optional is true
+type dotExpr struct {
+	obj      expression
+	field    *ident
+	optional bool
+}
+
+func (e *dotExpr) String() string {
+	if e.optional {
+		return e.obj.String() + "?." + e.field.src
+	}
+	return e.obj.String() + "." + e.field.src
+}
+
+func (e *dotExpr) eval(sc *scope) (interface{}, error) {
+	v, _, err := e.evalChain(sc)
+	return v, err
+}
+
+func (e *dotExpr) evalChain(sc *scope) (interface{}, bool, error) {
+	v, absent, err := evalObj(e.obj, sc)
+	if err != nil || absent {
+		return nil, absent, err
+	}
+	if e.optional && isNil(v) {
+		return nil, true, nil
+	}
+	result, err := field(v, e.field, sc.opts)
+	// only a missing key ends the chain, the other errors (e.g. a field which is not in the struct) are mistakes
+	if me, ok := err.(*missingError); ok {
+		if e.optional {
+			return nil, true, nil
+		}
+		result, err = sc.missing(me)
+	}
+	return result, false, err
+}
+
+//indexExpr is obj[index], or obj?[index] if optional is true
+type indexExpr struct {
+	obj      expression
+	index    expression
+	optional bool
+}
+
+func (e *indexExpr) String() string {
+	if e.optional {
+		return fmt.Sprintf("%s?[%s]", e.obj, e.index)
+	}
+	return fmt.Sprintf("%s[%s]", e.obj, e.index)
+}
+
+func (e *indexExpr) eval(sc *scope) (interface{}, error) {
+	v, _, err := e.evalChain(sc)
+	return v, err
+}
+
+func (e *indexExpr) evalChain(sc *scope) (interface{}, bool, error) {
+	v, absent, err := evalObj(e.obj, sc)
+	if err != nil || absent {
+		return nil, absent, err
+	}
+	if e.optional && isNil(v) {
+		return nil, true, nil
+	}
+	idx, err := e.index.eval(sc)
+	if err != nil {
+		return nil, false, err
+	}
+	result, err := index(v, idx)
+	// only a missing key ends the chain, the other errors (e.g. an index out of range) are mistakes
+	if me, ok := err.(*missingError); ok {
+		if e.optional {
+			return nil, true, nil
+		}
+		result, err = sc.missing(me)
+	}
+	return result, false, err
+}
+
+//sliceExpr is obj[low:high] (or obj?[low:high] if optional is true), low or high is nil if it is omitted
+type sliceExpr struct {
+	obj      expression
+	low      expression
+	high     expression
+	optional bool
+}
+
+func (e *sliceExpr) String() string {
+	var low, high string
+	if e.low != nil {
+		low = e.low.String()
+	}
+	if e.high != nil {
+		high = e.high.String()
+	}
+	if e.optional {
+		return fmt.Sprintf("%s?[%s:%s]", e.obj, low, high)
+	}
+	return fmt.Sprintf("%s[%s:%s]", e.obj, low, high)
+}
+
+func (e *sliceExpr) eval(sc *scope) (interface{}, error) {
+	v, _, err := e.evalChain(sc)
+	return v, err
+}
+
+func (e *sliceExpr) evalChain(sc *scope) (interface{}, bool, error) {
+	v, absent, err := evalObj(e.obj, sc)
+	if err != nil || absent {
+		return nil, absent, err
+	}
+	if e.optional && isNil(v) {
+		return nil, true, nil
+	}
+	var low, high interface{}
+	if e.low != nil {
+		if low, err = e.low.eval(sc); err != nil {
+			return nil, false, err
+		}
+	}
+	if e.high != nil {
+		if high, err = e.high.eval(sc); err != nil {
+			return nil, false, err
+		}
+	}
+	result, err := slice(v, low, high)
+	return result, false, err
+}
+
+//listExpr is a list literal, it is evaluated to []interface{}
+type listExpr struct {
+	items []expression
+}
+
+func (e *listExpr) String() string {
+	l := make([]string, len(e.items))
+	for i, item := range e.items {
+		l[i] = item.String()
+	}
+	return "[" + strings.Join(l, ", ") + "]"
+}
+
+func (e *listExpr) eval(sc *scope) (interface{}, error) {
+	l := make([]interface{}, len(e.items))
+	for i, item := range e.items {
+		v, err := item.eval(sc)
+		if err != nil {
+			return nil, err
+		}
+		l[i] = v
+	}
+	return l, nil
+}
+
+//mapExpr is a map literal, it is evaluated to map[string]interface{}, items are the keys and the values in turn
+type mapExpr struct {
+	items []expression
+}
+
+func (e *mapExpr) String() string {
+	l := make([]string, 0, len(e.items)/2)
+	for i := 0; i < len(e.items); i += 2 {
+		l = append(l, e.items[i].String()+": "+e.items[i+1].String())
+	}
+	return "{" + strings.Join(l, ", ") + "}"
+}
+
+func (e *mapExpr) eval(sc *scope) (interface{}, error) {
+	m := make(map[string]interface{}, len(e.items)/2)
+	for i := 0; i < len(e.items); i += 2 {
+		k, err := e.items[i].eval(sc)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("nbfmt.mapExpr.eval() error: key of map literal must be string (%v, type: %T)", k, k)
+		}
+		v, err := e.items[i+1].eval(sc)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+type callExpr struct {
+	fn   expression
+	args []expression
+}
+
+func (e *callExpr) String() string {
+	l := make([]string, len(e.args))
+	for i, arg := range e.args {
+		l[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", e.fn, strings.Join(l, ", "))
+}
+
+func (e *callExpr) eval(sc *scope) (interface{}, error) {
+	v, _, err := e.evalChain(sc)
+	return v, err
+}
+
+func (e *callExpr) evalChain(sc *scope) (interface{}, bool, error) {
+	fn, absent, err := evalObj(e.fn, sc)
+	if err != nil || absent {
+		return nil, absent, err
+	}
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		v, err := arg.eval(sc)
+		if err != nil {
+			return nil, false, err
+		}
+		args[i] = v
+	}
+	result, err := call(sc, fn, args)
+	return result, false, err
+}
+
+//definedExpr is defined(x), it is true if x exists (its value can be nil), whatever the Missing option is
+type definedExpr struct {
+	call *callExpr
+}
+
+func (e *definedExpr) String() string {
+	return e.call.String()
+}
+
+func (e *definedExpr) eval(sc *scope) (interface{}, error) {
+	// the variable named defined in env takes precedence like the other builtins
+	if _, ok, err := sc.lookup("defined"); ok || err != nil {
+		return e.call.eval(sc)
+	}
+	_, ok, err := evalDefined(e.call.args[0], sc)
+	return ok, err
+}
+
+//defaultExpr is default(x, fallback), it is x if x exists and it is not nil or the empty string, otherwise it is fallback,
+//fallback is evaluated only if it is used
+type defaultExpr struct {
+	call *callExpr
+}
+
+func (e *defaultExpr) String() string {
+	return e.call.String()
+}
+
+func (e *defaultExpr) eval(sc *scope) (interface{}, error) {
+	if _, ok, err := sc.lookup("default"); ok || err != nil {
+		return e.call.eval(sc)
+	}
+	v, ok, err := evalDefined(e.call.args[0], sc)
+	if err != nil {
+		return nil, err
+	}
+	if ok && !isNil(v) && v != "" {
+		return v, nil
+	}
+	return e.call.args[1].eval(sc)
+}
+
+//evalDefined evaluates e, ok is false if a variable or key in e does not exist or an optional chain in e is cut off
+func evalDefined(e expression, sc *scope) (v interface{}, ok bool, err error) {
+	v, absent, err := evalObj(e, sc.strict())
+	if isMissing(err) {
+		return nil, false, nil
+	}
+	return v, !absent, err
+}
+
+func assertToInt(lv, rv interface{}) (int64, int64, bool) {
+	ilv, ok := lv.(int64)
+	if !ok {
+		return 0, 0, false
+	}
+	irv, ok := rv.(int64)
+	if !ok {
+		return 0, 0, false
+	}
+	return ilv, irv, true
+}
+
+func assertToFloat(lv, rv interface{}) (float64, float64, bool) {
+	flv, ok := lv.(float64)
+	if !ok {
+		return 0, 0, false
+	}
+	frv, ok := rv.(float64)
+	if !ok {
+		return 0, 0, false
+	}
+	return flv, frv, true
+}
+
+func assertToStr(lv, rv interface{}) (string, string, bool) {
+	slv, ok := lv.(string)
+	if !ok {
+		return "", "", false
+	}
+	srv, ok := rv.(string)
+	if !ok {
+		return "", "", false
+	}
+	return slv, srv, true
+}
+
+func assertToBool(lv, rv interface{}) (bool, bool, bool) {
+	blv, ok := lv.(bool)
+	if !ok {
+		return false, false, false
+	}
+	brv, ok := rv.(bool)
+	if !ok {
+		return false, false, false
+	}
+	return blv, brv, true
+}
+
+func assertToByte(lv, rv interface{}) (byte, byte, bool) {
 	blv, ok := lv.(byte)
 	if !ok {
 		return 0, 0, false
@@ -709,64 +1533,77 @@ func div(lv, rv interface{}) (interface{}, error) {
 		}
 		return flv / frv, nil
 	}
+	if irv == 0 {
+		return nil, fmt.Errorf("nbfmt.div() error: integer divide by zero (%d / %d)", ilv, irv)
+	}
 	return ilv / irv, nil
 }
 
 func equal(lv, rv interface{}) (bool, error) {
-	return lv == rv, nil
+	return same(lv, rv), nil
+}
+
+//contains is the result of item in container, container can be slice, array, map (item is a key) or string (item is a substring)
+func contains(container, item interface{}) (bool, error) {
+	val := reflect.ValueOf(container)
+	switch val.Kind() {
+	case reflect.Invalid:
+		return false, nil
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if same(normalize(val.Index(i).Interface()), item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		key, ok := convertKey(item, val.Type().Key())
+		if !ok {
+			return false, nil
+		}
+		return val.MapIndex(key).IsValid(), nil
+	case reflect.String:
+		s, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("nbfmt.contains() error: cannot find %v (%T) in string", item, item)
+		}
+		return strings.Contains(val.String(), s), nil
+	default:
+		return false, fmt.Errorf("nbfmt.contains() error: %T is not a list, map or string", container)
+	}
 }
 
 func notEqual(lv, rv interface{}) (bool, error) {
-	return lv != rv, nil
+	return !same(lv, rv), nil
 }
 
-func lessThan(lv, rv interface{}) (bool, error) {
-	ilv, irv, ok := assertToInt(lv, rv)
+//order compares lv and rv for the ordering operators, it is an error if they cannot be ordered
+func order(op *operator, lv, rv interface{}) (int, error) {
+	c, ok := compare(lv, rv)
 	if !ok {
-		flv, frv, ok := assertToFloat(lv, rv)
-		if !ok {
-			return false, fmt.Errorf("nbfmt.lessThan() cannot compare (%T and %T)\n", lv, rv)
-		}
-		return flv < frv, nil
+		return 0, fmt.Errorf("nbfmt.order() error: cannot compare (%v %s %v) (%T and %T)", lv, op, rv, lv, rv)
 	}
-	return ilv < irv, nil
+	return c, nil
+}
 
+func lessThan(lv, rv interface{}) (bool, error) {
+	c, err := order(&lessThanOperator, lv, rv)
+	return c < 0, err
 }
 
 func lessThanEqual(lv, rv interface{}) (bool, error) {
-	ilv, irv, ok := assertToInt(lv, rv)
-	if !ok {
-		flv, frv, ok := assertToFloat(lv, rv)
-		if !ok {
-			return false, fmt.Errorf("nbfmt.lessEqualThan() cannot compare (%T and %T)\n", lv, rv)
-		}
-		return flv <= frv, nil
-	}
-	return ilv <= irv, nil
+	c, err := order(&lessThanEqualOperator, lv, rv)
+	return c <= 0 && err == nil, err
 }
 
 func greatThan(lv, rv interface{}) (bool, error) {
-	ilv, irv, ok := assertToInt(lv, rv)
-	if !ok {
-		flv, frv, ok := assertToFloat(lv, rv)
-		if !ok {
-			return false, fmt.Errorf("nbfmt.greatThan() cannot compare (%T and %T)\n", lv, rv)
-		}
-		return flv > frv, nil
-	}
-	return ilv > irv, nil
+	c, err := order(&greatThanOperator, lv, rv)
+	return c > 0, err
 }
 
 func greatThanEqual(lv, rv interface{}) (bool, error) {
-	ilv, irv, ok := assertToInt(lv, rv)
-	if !ok {
-		flv, frv, ok := assertToFloat(lv, rv)
-		if !ok {
-			return false, fmt.Errorf("nbfmt.greatEqualThan() cannot compare (%T and %T)\n", lv, rv)
-		}
-		return flv >= frv, nil
-	}
-	return ilv >= irv, nil
+	c, err := order(&greatThanEqualOperator, lv, rv)
+	return c >= 0 && err == nil, err
 }
 
 func and(lv, rv interface{}) (bool, error) {
@@ -787,380 +1624,418 @@ func or(lv, rv interface{}) (bool, error) {
 
 func deref(i interface{}) (interface{}, error) {
 	val := reflect.ValueOf(i)
-	if val.Type().Kind() != reflect.Ptr {
+	if !val.IsValid() || (val.Kind() == reflect.Ptr && val.IsNil()) {
+		return nil, fmt.Errorf("nbfmt.deref() error: nil value (%T)", i)
+	}
+	if val.Kind() != reflect.Ptr {
 		return nil, fmt.Errorf("nbfmt.deref() error: invalid dereference operate for %T (%v)", i, i)
 	}
 	return val.Elem().Interface(), nil
 }
 
-func field(s interface{}, f *ident) (interface{}, error) {
+//field returns the field of struct or the value of map by the name of f, opts changes how the fields of struct are named
+func field(s interface{}, f *ident, opts *options) (interface{}, error) {
 	if f.typ != varIdent {
 		return nil, fmt.Errorf("nbfmt.field() error: field ident is not varIdent (%s)", f.src)
 	}
+	if l, ok := s.(*loopVar); ok {
+		return l.field(f.src)
+	}
 	val := reflect.ValueOf(s)
 	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			if err := checkField(val.Type(), s, f.src, opts); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("nbfmt.field() error: cannot get %s field of nil pointer (%T)", f.src, s)
+		}
 		val = val.Elem()
 	}
-	if val.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("nbfmt.field() error: %v is not struct", s)
-	}
-	field := val.FieldByName(f.src)
-	if !field.IsValid() {
-		return nil, fmt.Errorf("nbfmt.field() error: %s field is not valid", f.src)
-	}
-	return field.Interface(), nil
-}
-
-func index(obj, idx interface{}) (interface{}, error) {
-	val := reflect.ValueOf(obj)
 	switch val.Kind() {
 	case reflect.Map:
-		v := val.MapIndex(reflect.ValueOf(idx))
-		if !v.IsValid() {
-			return nil, fmt.Errorf("nbfmt.index() error: invalid map element (index: %v)", idx)
+		// m.key is the same as m["key"] for the maps with string keys (e.g. the result of json.Unmarshal)
+		key, ok := convertKey(f.src, val.Type().Key())
+		if !ok {
+			return nil, fmt.Errorf("nbfmt.field() error: %T has no string keys (%s)", s, f.src)
 		}
-		result := v.Interface()
-		switch r := result.(type) {
-		case int:
-			return int64(r), nil
-		case float32:
-			return float64(r), nil
+		v := val.MapIndex(key)
+		if !v.IsValid() {
+			return nil, &missingError{fmt.Sprintf("nbfmt.field() error: %s key is not exist in map", f.src)}
 		}
-		return result, nil
-	case reflect.Array, reflect.Slice:
-		if int64Idx, ok := idx.(int64); ok {
-			v := val.Index(int(int64Idx))
-			if !v.IsValid() {
-				return nil, fmt.Errorf("nbfmt.index() error: invalid array element (index: %v)", idx)
+		return normalize(v.Interface()), nil
+	case reflect.Struct:
+		sf, ok := lookupField(val.Type(), f.src, opts)
+		if !ok {
+			if m, ok := method(s, f.src); ok {
+				return m, nil
 			}
-			result := v.Interface()
-			switch r := result.(type) {
-			case int:
-				return int64(r), nil
-			case float32:
-				return float64(r), nil
+			return nil, checkField(val.Type(), s, f.src, opts)
+		}
+		if sf.PkgPath != "" {
+			return nil, fmt.Errorf("nbfmt.field() error: %s field of %s is unexported", f.src, val.Type())
+		}
+		// the promoted field is reached through the embedded structs, which may be nil pointers
+		v := val
+		for i, x := range sf.Index {
+			if i > 0 && v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return nil, fmt.Errorf("nbfmt.field() error: cannot get %s field through nil embedded %s", f.src, v.Type())
+				}
+				v = v.Elem()
 			}
-			return result, nil
+			v = v.Field(x)
+		}
+		if !v.CanInterface() {
+			return nil, fmt.Errorf("nbfmt.field() error: %s field of %s is unexported", f.src, val.Type())
 		}
-		return nil, fmt.Errorf("nbfmt.index() error: invalid index for array or slice (index: %v, type: %T)", idx, idx)
+		return normalize(v.Interface()), nil
 	default:
-		return nil, fmt.Errorf("nbfmt.index() error: cannot index %T (%v)", obj, obj)
+		if m, ok := method(s, f.src); ok {
+			return m, nil
+		}
+		return nil, fmt.Errorf("nbfmt.field() error: %v is not struct or map", s)
 	}
 }
 
-func not(i interface{}) (bool, error) {
-	boolVal, ok := i.(bool)
-	if !ok {
-		return false, fmt.Errorf("nbfmt.not() error: invalid not operate for %T type (%v)", i, i)
+//checkField returns the error of name which is not a field of struct type typ (or of the struct typ points to):
+//noFieldError if the struct has neither the field nor the method of s, or the error of an ambiguous field which
+//is promoted from several embedded structs. It returns nil for the other types and the names which are found
+func checkField(typ reflect.Type, s interface{}, name string, opts *options) error {
+	st := typ
+	for st.Kind() == reflect.Ptr {
+		st = st.Elem()
 	}
-	return !boolVal, nil
+	if st.Kind() != reflect.Struct {
+		return nil
+	}
+	if _, ok := lookupField(st, name, opts); ok {
+		return nil
+	}
+	if _, ok := reflect.TypeOf(s).MethodByName(name); ok {
+		return nil
+	}
+	if hasField(st, name, make(map[reflect.Type]bool)) {
+		return fmt.Errorf("nbfmt.field() error: %s field of %s is ambiguous", name, st)
+	}
+	return &noFieldError{fmt.Sprintf("nbfmt.field() error: %s field is not valid", name)}
 }
 
-func (e *expression) copy() *expression {
-	ne := &expression{}
-	if e.ident != nil {
-		nid := *e.ident
-		ne.ident = &nid
+//hasField reports whether struct type typ or its embedded structs have a field named name
+func hasField(typ reflect.Type, name string, seen map[reflect.Type]bool) bool {
+	if seen[typ] {
+		return false
 	}
-	// id := *e.ident
-	op := e.operator
-	// ne.ident = &id
-	ne.operator = op
-	if e.subExpr != nil {
-		ne.subExpr = e.subExpr.copy()
-		ne.subExpr.parExpr = ne
+	seen[typ] = true
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.Name == name {
+			return true
+		}
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && ft.Kind() == reflect.Struct && hasField(ft, name, seen) {
+			return true
+		}
 	}
-	if e.nextExpr != nil {
-		ne.nextExpr = e.nextExpr.copy()
-		ne.nextExpr.prevExpr = ne
+	return false
+}
+
+//method returns the exported method of s by name as a function value, so it can be called in expressions (e.g. v.FullName())
+func method(s interface{}, name string) (interface{}, bool) {
+	if s == nil {
+		return nil, false
+	}
+	m := reflect.ValueOf(s).MethodByName(name)
+	if !m.IsValid() {
+		return nil, false
 	}
-	return ne
+	return m.Interface(), true
 }
 
-func (e *expression) eval(env map[string]interface{}) (interface{}, error) {
-	priExpr := e.pop()
-	nextExpr := priExpr.nextExpr
-	leftVal := func() (interface{}, error) {
-		if priExpr.value == nil && priExpr.ident != nil {
-			v, err := priExpr.ident.eval(env)
-			if err != nil {
-				return nil, err
+//convertKey converts idx to the key type of map, numbers are converted to the numeric key type if the value fits,
+//strings are converted to the string key type, ok is false if idx cannot be a key of the map
+func convertKey(idx interface{}, typ reflect.Type) (key reflect.Value, ok bool) {
+	if idx == nil {
+		return reflect.Value{}, false
+	}
+	val := reflect.ValueOf(idx)
+	switch {
+	case val.Type().AssignableTo(typ):
+		return val, true
+	case isIntValue(val) && isNumberKind(typ.Kind()):
+		key = reflect.New(typ).Elem()
+		switch {
+		case isIntValue(key):
+			if key.OverflowInt(val.Int()) {
+				return reflect.Value{}, false
+			}
+		case isUintValue(key):
+			if val.Int() < 0 || key.OverflowUint(uint64(val.Int())) {
+				return reflect.Value{}, false
 			}
-			priExpr.value = v
 		}
-		return priExpr.value, nil
-	}
-	rightVal := func() (interface{}, error) {
-		if nextExpr.value == nil {
-			v, err := nextExpr.ident.eval(env)
-			if err != nil {
-				return nil, err
+		return val.Convert(typ), true
+	case isUintValue(val) && isNumberKind(typ.Kind()):
+		key = reflect.New(typ).Elem()
+		switch {
+		case isIntValue(key):
+			if val.Uint() > math.MaxInt64 || key.OverflowInt(int64(val.Uint())) {
+				return reflect.Value{}, false
+			}
+		case isUintValue(key):
+			if key.OverflowUint(val.Uint()) {
+				return reflect.Value{}, false
 			}
-			nextExpr.value = v
 		}
-		return nextExpr.value, nil
+		return val.Convert(typ), true
+	case (val.Kind() == reflect.Float32 || val.Kind() == reflect.Float64) && (typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64):
+		return val.Convert(typ), true
+	case val.Kind() == reflect.String && typ.Kind() == reflect.String:
+		return val.Convert(typ), true
+	default:
+		return reflect.Value{}, false
 	}
-	virExpr := &expression{}
-	switch priExpr.operator {
-	case &derefOperator:
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := deref(rv)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &notOperator:
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := not(rv)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &plugOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
-		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := add(lv, rv)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &subOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
-		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := sub(lv, rv)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &mulOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
-		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := mul(lv, rv)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &divOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
-		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := mul(lv, rv)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &dotOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := field(lv, nextExpr.ident)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &indexOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
-		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := index(lv, rv)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &equalOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
-		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
-		}
-		result, err := equal(lv, rv)
-		if err != nil {
-			return nil, err
-		}
-		virExpr.value = result
-	case &notEqualOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
-		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
+}
+
+func index(obj, idx interface{}) (interface{}, error) {
+	val := reflect.ValueOf(obj)
+	switch val.Kind() {
+	case reflect.Map:
+		key, ok := convertKey(idx, val.Type().Key())
+		if !ok {
+			return nil, fmt.Errorf("nbfmt.index() error: invalid key type for %T (index: %v, type: %T)", obj, idx, idx)
 		}
-		result, err := notEqual(lv, rv)
-		if err != nil {
-			return nil, err
+		v := val.MapIndex(key)
+		if !v.IsValid() {
+			return nil, &missingError{fmt.Sprintf("nbfmt.index() error: invalid map element (index: %v)", idx)}
 		}
-		virExpr.value = result
-	case &lessThanOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
+		result := v.Interface()
+		switch r := result.(type) {
+		case int:
+			return int64(r), nil
+		case float32:
+			return float64(r), nil
 		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
+		return result, nil
+	case reflect.Array, reflect.Slice:
+		i, ok := toIndex(idx)
+		if !ok {
+			return nil, fmt.Errorf("nbfmt.index() error: invalid index for array or slice (index: %v, type: %T)", idx, idx)
 		}
-		result, err := lessThan(lv, rv)
-		if err != nil {
-			return nil, err
+		// negative index counts from the end
+		if i < 0 {
+			i += int64(val.Len())
 		}
-		virExpr.value = result
-	case &lessThanEqualOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
+		if i < 0 || i >= int64(val.Len()) {
+			return nil, fmt.Errorf("nbfmt.index() error: index out of range (index: %v, length: %d)", idx, val.Len())
 		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
+		result := val.Index(int(i)).Interface()
+		switch r := result.(type) {
+		case int:
+			return int64(r), nil
+		case float32:
+			return float64(r), nil
 		}
-		result, err := lessThanEqual(lv, rv)
-		if err != nil {
-			return nil, err
+		return result, nil
+	case reflect.String:
+		i, ok := toIndex(idx)
+		if !ok {
+			return nil, fmt.Errorf("nbfmt.index() error: invalid index for string (index: %v, type: %T)", idx, idx)
 		}
-		virExpr.value = result
-	case &greatThanOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
+		// strings are indexed by characters as they are iterated
+		runes := []rune(val.String())
+		if i < 0 {
+			i += int64(len(runes))
 		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
+		if i < 0 || i >= int64(len(runes)) {
+			return nil, fmt.Errorf("nbfmt.index() error: index out of range (index: %v, length: %d)", idx, len(runes))
 		}
-		result, err := greatThan(lv, rv)
-		if err != nil {
-			return nil, err
+		return string(runes[i]), nil
+	default:
+		return nil, fmt.Errorf("nbfmt.index() error: cannot index %T (%v)", obj, obj)
+	}
+}
+
+func toIndex(idx interface{}) (int64, bool) {
+	val := reflect.ValueOf(idx)
+	switch {
+	case isIntValue(val):
+		return val.Int(), true
+	case isUintValue(val):
+		return int64(val.Uint()), true
+	default:
+		return 0, false
+	}
+}
+
+//sliceIndexes returns the bounds of slice expression for length like python, the negative bounds count from the end
+//and the bounds out of range are clamped, so the result is empty instead of an error
+func sliceIndexes(low, high interface{}, length int) (int, int, error) {
+	bounds := [2]int{0, length}
+	for i, b := range [2]interface{}{low, high} {
+		if b == nil {
+			continue
 		}
-		virExpr.value = result
-	case &greatThanEqualOperator:
-		lv, err := leftVal()
-		if err != nil {
-			return nil, err
+		v, ok := toIndex(b)
+		if !ok {
+			return 0, 0, fmt.Errorf("nbfmt.slice() error: invalid slice index (%v, type: %T)", b, b)
 		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
+		if v < 0 {
+			v += int64(length)
 		}
-		result, err := greatThanEqual(lv, rv)
-		if err != nil {
-			return nil, err
+		switch {
+		case v < 0:
+			v = 0
+		case v > int64(length):
+			v = int64(length)
 		}
-		virExpr.value = result
-	case &andOperator:
-		lv, err := leftVal()
+		bounds[i] = int(v)
+	}
+	if bounds[0] > bounds[1] {
+		bounds[0] = bounds[1]
+	}
+	return bounds[0], bounds[1], nil
+}
+
+//slice returns obj[low:high] for slice, array and string (by characters), nil bound means the start or the end
+func slice(obj, low, high interface{}) (interface{}, error) {
+	val := reflect.ValueOf(obj)
+	switch val.Kind() {
+	case reflect.Slice:
+		lo, hi, err := sliceIndexes(low, high, val.Len())
 		if err != nil {
 			return nil, err
 		}
-		rv, err := rightVal()
+		return val.Slice(lo, hi).Interface(), nil
+	case reflect.Array:
+		lo, hi, err := sliceIndexes(low, high, val.Len())
 		if err != nil {
 			return nil, err
 		}
-		result, err := and(lv, rv)
-		if err != nil {
-			return nil, err
+		// an array in interface{} is not addressable, so the elements are copied into a new slice
+		result := reflect.MakeSlice(reflect.SliceOf(val.Type().Elem()), hi-lo, hi-lo)
+		for i := lo; i < hi; i++ {
+			result.Index(i - lo).Set(val.Index(i))
 		}
-		virExpr.value = result
-	case &orOperator:
-		lv, err := leftVal()
+		return result.Interface(), nil
+	case reflect.String:
+		runes := []rune(val.String())
+		lo, hi, err := sliceIndexes(low, high, len(runes))
 		if err != nil {
 			return nil, err
 		}
-		rv, err := rightVal()
-		if err != nil {
-			return nil, err
+		return string(runes[lo:hi]), nil
+	default:
+		return nil, fmt.Errorf("nbfmt.slice() error: cannot slice %T (%v)", obj, obj)
+	}
+}
+
+func not(i interface{}) (bool, error) {
+	boolVal, ok := i.(bool)
+	if !ok {
+		return false, fmt.Errorf("nbfmt.not() error: invalid not operate for %T type (%v)", i, i)
+	}
+	return !boolVal, nil
+}
+
+func neg(i interface{}) (interface{}, error) {
+	switch v := i.(type) {
+	case int64:
+		return -v, nil
+	case float64:
+		return -v, nil
+	default:
+		return nil, fmt.Errorf("nbfmt.neg() error: invalid negative operate for %T type (%v)", i, i)
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+var scopeType = reflect.TypeOf((*scope)(nil))
+
+//call calls the function fn with args, fn can return one value, or one value and an error.
+//The builtins whose first parameter is *scope get sc before args
+func call(sc *scope, fn interface{}, args []interface{}) (interface{}, error) {
+	fnVal := reflect.ValueOf(fn)
+	if fnVal.Kind() != reflect.Func {
+		return nil, fmt.Errorf("nbfmt.call() error: %T is not a function", fn)
+	}
+	fnTyp := fnVal.Type()
+	in := make([]reflect.Value, 0, len(args)+1)
+	if fnTyp.NumIn() > 0 && fnTyp.In(0) == scopeType {
+		in = append(in, reflect.ValueOf(sc))
+	}
+	offset := len(in)
+	numIn := fnTyp.NumIn() - offset
+	if (fnTyp.IsVariadic() && len(args) < numIn-1) || (!fnTyp.IsVariadic() && len(args) != numIn) {
+		return nil, fmt.Errorf("nbfmt.call() error: wrong number of arguments for %T (%d supplied)", fn, len(args))
+	}
+	for i, arg := range args {
+		var argTyp reflect.Type
+		if fnTyp.IsVariadic() && i >= numIn-1 {
+			argTyp = fnTyp.In(fnTyp.NumIn() - 1).Elem()
+		} else {
+			argTyp = fnTyp.In(i + offset)
 		}
-		result, err := or(lv, rv)
+		v, err := convertArg(arg, argTyp)
 		if err != nil {
 			return nil, err
 		}
-		virExpr.value = result
-	case nil:
-		lv, err := leftVal()
-		if err != nil {
+		in = append(in, v)
+	}
+	out := fnVal.Call(in)
+	switch {
+	case len(out) == 1 && !fnTyp.Out(0).Implements(errorType):
+		return normalize(out[0].Interface()), nil
+	case len(out) == 2 && fnTyp.Out(1) == errorType:
+		if err, _ := out[1].Interface().(error); err != nil {
 			return nil, err
 		}
-		virExpr.value = lv
-	}
-	if nextExpr != nil {
-		if nextExpr.nextExpr != nil {
-			virExpr.nextExpr = nextExpr.nextExpr
-			virExpr.operator = nextExpr.operator
-			nextExpr.nextExpr.prevExpr = virExpr
-			if priExpr.prevExpr != nil {
-				virExpr.prevExpr = priExpr.prevExpr
-				priExpr.prevExpr.nextExpr = virExpr
-			} else {
-				if priExpr.parExpr != nil {
-					virExpr.parExpr = priExpr.parExpr
-					priExpr.parExpr.subExpr = virExpr
-				} else {
-					e = virExpr
-				}
-			}
-		} else {
-			if priExpr.prevExpr != nil {
-				virExpr.prevExpr = priExpr.prevExpr
-				priExpr.prevExpr.nextExpr = virExpr
-			} else {
-				if priExpr.parExpr != nil {
-					priExpr.parExpr.value = virExpr.value
-				} else {
-					return virExpr.value, nil
-				}
-			}
-		}
-	} else {
-		if priExpr.prevExpr != nil {
-			virExpr.prevExpr = priExpr.prevExpr
-			priExpr.prevExpr.nextExpr = virExpr
-		} else {
-			if priExpr.parExpr != nil {
-				priExpr.parExpr.value = virExpr.value
-			} else {
-				return virExpr.value, nil
-			}
+		return normalize(out[0].Interface()), nil
+	default:
+		return nil, fmt.Errorf("nbfmt.call() error: %T must return a value or a value and an error", fn)
+	}
+}
+
+func convertArg(arg interface{}, typ reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		switch typ.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+			return reflect.Zero(typ), nil
+		default:
+			return reflect.Value{}, fmt.Errorf("nbfmt.convertArg() error: cannot use nil as %s argument", typ)
 		}
 	}
-	return e.eval(env)
+	val := reflect.ValueOf(arg)
+	if val.Type().AssignableTo(typ) {
+		return val, nil
+	}
+	if isNumberKind(val.Kind()) && isNumberKind(typ.Kind()) {
+		return val.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("nbfmt.convertArg() error: cannot use %v (%T) as %s argument", arg, arg, typ)
+}
 
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+//normalize converts int and float32 to int64 and float64 which are used by operators
+func normalize(v interface{}) interface{} {
+	switch r := v.(type) {
+	case int:
+		return int64(r)
+	case float32:
+		return float64(r)
+	}
+	return v
 }
@@ -1,5 +1,13 @@
 package nbfmt
 
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
 // func stringCompare(s1, s2 string, op object) (bool, error) {
 // 	switch op.idents[0].typ {
 // 	case eqIdent:
@@ -67,3 +75,181 @@ package nbfmt
 // 		return false, fmt.Errorf("cannot compare bool values with %v operator", op)
 // 	}
 // }
+
+//compare returns a negative number, zero or a positive number when a is less than, equal to or greater than b,
+//ok is false if a and b cannot be ordered. Numbers are compared by value whatever their types are (time.Duration
+//is a number), strings and []byte are compared by bytes, time.Time is compared by instant and Comparable values
+//are compared by Compare method if both of them have the same type
+func compare(a, b interface{}) (c int, ok bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	// Compare is only called with the values of the same type, so it can assert the type of other
+	if ca, isComparable := a.(Comparable); isComparable {
+		if reflect.TypeOf(a) != reflect.TypeOf(b) {
+			return 0, false
+		}
+		return ca.Compare(b), true
+	}
+	if _, isComparable := b.(Comparable); isComparable {
+		return 0, false
+	}
+	if ta, isTime := a.(time.Time); isTime {
+		tb, isTime := b.(time.Time)
+		if !isTime {
+			return 0, false
+		}
+		return compareOrdered(ta.Before(tb), ta.After(tb)), true
+	}
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case isIntValue(av) && isIntValue(bv):
+		return compareOrdered(av.Int() < bv.Int(), av.Int() > bv.Int()), true
+	case isUintValue(av) && isUintValue(bv):
+		return compareOrdered(av.Uint() < bv.Uint(), av.Uint() > bv.Uint()), true
+	case isIntValue(av) && isUintValue(bv):
+		return compareOrdered(av.Int() < 0 || uint64(av.Int()) < bv.Uint(), av.Int() >= 0 && uint64(av.Int()) > bv.Uint()), true
+	case isUintValue(av) && isIntValue(bv):
+		c, _ := compare(b, a)
+		return -c, true
+	case isNumberValue(av) && isNumberValue(bv):
+		af, bf := toFloat(av), toFloat(bv)
+		return compareOrdered(af < bf, af > bf), true
+	case av.Kind() == reflect.String && bv.Kind() == reflect.String:
+		return strings.Compare(av.String(), bv.String()), true
+	case isBytesValue(av) && (isBytesValue(bv) || bv.Kind() == reflect.String):
+		return bytes.Compare(av.Bytes(), toBytes(bv)), true
+	case av.Kind() == reflect.String && isBytesValue(bv):
+		return bytes.Compare([]byte(av.String()), bv.Bytes()), true
+	case av.Kind() == reflect.Bool && bv.Kind() == reflect.Bool:
+		return compareOrdered(!av.Bool() && bv.Bool(), av.Bool() && !bv.Bool()), true
+	default:
+		return 0, false
+	}
+}
+
+//same reports whether a and b are equal. The values which can be ordered by compare are equal if compare returns 0,
+//so the numbers of different types are compared by value. Lists, maps and structs are compared element by element
+//by same, and nil equals the nil pointers, maps, slices and funcs. Unlike ==, same never panics
+func same(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return isNil(a) && isNil(b)
+	}
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case isListValue(av) && isListValue(bv):
+		if av.Len() != bv.Len() {
+			return false
+		}
+		for i := 0; i < av.Len(); i++ {
+			if !same(av.Index(i).Interface(), bv.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+	case av.Kind() == reflect.Map && bv.Kind() == reflect.Map:
+		if av.Len() != bv.Len() {
+			return false
+		}
+		iter := av.MapRange()
+		for iter.Next() {
+			key, ok := convertKey(iter.Key().Interface(), bv.Type().Key())
+			if !ok {
+				return false
+			}
+			bElem := bv.MapIndex(key)
+			if !bElem.IsValid() || !same(iter.Value().Interface(), bElem.Interface()) {
+				return false
+			}
+		}
+		return true
+	case av.Kind() == reflect.Struct && av.Type() == bv.Type():
+		for i := 0; i < av.NumField(); i++ {
+			// the unexported fields cannot be read by Interface, so the struct is compared deeply as a whole
+			if av.Type().Field(i).PkgPath != "" {
+				return reflect.DeepEqual(a, b)
+			}
+		}
+		for i := 0; i < av.NumField(); i++ {
+			if !same(av.Field(i).Interface(), bv.Field(i).Interface()) {
+				return false
+			}
+		}
+		return true
+	case av.Type() == bv.Type() && av.Type().Comparable():
+		return a == b
+	default:
+		return false
+	}
+}
+
+//compareValues orders any values, the values which cannot be ordered by compare are ordered by their string forms
+//so the order is always deterministic
+func compareValues(a, b interface{}) int {
+	if c, ok := compare(a, b); ok {
+		return c
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isIntValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	default:
+		return false
+	}
+}
+
+func isUintValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	default:
+		return false
+	}
+}
+
+func isBytesValue(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8
+}
+
+func isListValue(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+}
+
+func toBytes(v reflect.Value) []byte {
+	if v.Kind() == reflect.String {
+		return []byte(v.String())
+	}
+	return v.Bytes()
+}
+
+func isNumberValue(v reflect.Value) bool {
+	return isNumberKind(v.Kind())
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case isIntValue(v):
+		return float64(v.Int())
+	case isUintValue(v):
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
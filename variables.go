@@ -0,0 +1,194 @@
+package nbfmt
+
+import (
+	"sort"
+	"strings"
+)
+
+//Variables returns the variables which the template reads from env, sorted and without duplicates. A variable is
+//reported with its fields, e.g. user.Address.City, and [] stands for the elements of a list or map, so
+//{{ for item in order.Items }}{{ item.Price }}{{ endfor }} reports order.Items and order.Items[].Price.
+//The variables bound by for blocks (including loop) are not reported, the names of builtin functions are not reported
+//unless they are used as values
+func (t *Template) Variables() []string {
+	w := &varWalker{paths: make(map[string]bool)}
+	w.blocks(t.blocks)
+	l := make([]string, 0, len(w.paths))
+	for p := range w.paths {
+		l = append(l, p)
+	}
+	sort.Strings(l)
+	return l
+}
+
+//varWalker collects the paths of the free variables in the block tree
+type varWalker struct {
+	paths map[string]bool
+	//bound is the stack of the variables bound by the enclosing for blocks, a bound name maps to the path of
+	//the elements it iterates (e.g. order.Items[]), or to the empty string if they do not come from env
+	bound []map[string]string
+}
+
+func (w *varWalker) blocks(l []block) {
+	for _, b := range l {
+		w.block(b)
+	}
+}
+
+func (w *varWalker) block(b block) {
+	switch b := b.(type) {
+	case *valueBlock:
+		w.expr(b.exp)
+	case *transBlock:
+		w.expr(b.key)
+		for _, arg := range b.args {
+			w.expr(arg)
+		}
+	case *ifBlock:
+		for _, cb := range b.caseBlocks {
+			w.expr(cb.exp)
+			w.blocks(cb.subBlocks)
+		}
+		if b.defaultBlock != nil {
+			w.blocks(b.defaultBlock.subBlocks)
+		}
+	case *switchBlock:
+		// the target of a type switch is type(x), type is not a function
+		if call, ok := b.exp.(*callExpr); ok && b.typeSwitch {
+			for _, arg := range call.args {
+				w.expr(arg)
+			}
+		} else {
+			w.expr(b.exp)
+		}
+		for _, cb := range b.caseBlocks {
+			for _, e := range cb.exps {
+				w.expr(e)
+			}
+			w.blocks(cb.subBlocks)
+		}
+		if b.defaultBlock != nil {
+			w.blocks(b.defaultBlock.subBlocks)
+		}
+	case *forBlock:
+		w.expr(b.objExpr)
+		elem, ok := w.path(b.objExpr)
+		if ok {
+			elem = strings.TrimSuffix(elem, "[]") + "[]"
+		}
+		bound := map[string]string{"loop": ""}
+		if b.indexVarName != "" {
+			bound[b.indexVarName] = ""
+		}
+		bound[b.valueVarName] = elem
+		w.bound = append(w.bound, bound)
+		if b.filter != nil {
+			w.expr(b.filter)
+		}
+		w.blocks(b.subBlocks)
+		w.bound = w.bound[:len(w.bound)-1]
+		if b.elseBlock != nil {
+			w.blocks(b.elseBlock.subBlocks)
+		}
+	}
+}
+
+//lookup returns the path of a bound variable, bound is false if name is free
+func (w *varWalker) lookup(name string) (path string, bound bool) {
+	for i := len(w.bound) - 1; i >= 0; i-- {
+		if p, ok := w.bound[i][name]; ok {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+//path returns the path of the variable which e reads, ok is false if e is not a chain of fields and indexes
+//starting from a variable from env
+func (w *varWalker) path(e expression) (string, bool) {
+	switch e := e.(type) {
+	case *varExpr:
+		if p, bound := w.lookup(e.ident.src); bound {
+			return p, p != ""
+		}
+		return e.ident.src, true
+	case *dotExpr:
+		p, ok := w.path(e.obj)
+		return p + "." + e.field.src, ok
+	case *indexExpr:
+		p, ok := w.path(e.obj)
+		return p + "[]", ok
+	case *sliceExpr:
+		return w.path(e.obj)
+	}
+	return "", false
+}
+
+//expr adds the paths of the variables in e
+func (w *varWalker) expr(e expression) {
+	if p, ok := w.path(e); ok {
+		// the elements alone are covered by the list itself
+		for strings.HasSuffix(p, "[]") {
+			p = strings.TrimSuffix(p, "[]")
+		}
+		w.paths[p] = true
+	}
+	w.chain(e)
+}
+
+//chain adds the paths in the parts of e, the object of a field or an index is a part of the path of e
+//(if e has one) so it is not added by itself
+func (w *varWalker) chain(e expression) {
+	switch e := e.(type) {
+	case *dotExpr:
+		w.chain(e.obj)
+	case *indexExpr:
+		w.chain(e.obj)
+		w.expr(e.index)
+	case *sliceExpr:
+		w.chain(e.obj)
+		if e.low != nil {
+			w.expr(e.low)
+		}
+		if e.high != nil {
+			w.expr(e.high)
+		}
+	case *unaryExpr:
+		w.expr(e.operand)
+	case *binaryExpr:
+		w.expr(e.left)
+		w.expr(e.right)
+	case *listExpr:
+		for _, item := range e.items {
+			w.expr(item)
+		}
+	case *mapExpr:
+		for _, item := range e.items {
+			w.expr(item)
+		}
+	case *callExpr:
+		w.call(e)
+	case *definedExpr:
+		w.call(e.call)
+	case *defaultExpr:
+		w.call(e.call)
+	}
+}
+
+//call adds the paths in the function and the arguments of e, the builtin functions are not variables
+func (w *varWalker) call(e *callExpr) {
+	if fn, ok := e.fn.(*varExpr); !ok || !w.isBuiltin(fn.ident.src) {
+		w.expr(e.fn)
+	}
+	for _, arg := range e.args {
+		w.expr(arg)
+	}
+}
+
+func (w *varWalker) isBuiltin(name string) bool {
+	if _, bound := w.lookup(name); bound {
+		return false
+	}
+	_, ok := builtins[name]
+	return ok || name == "defined" || name == "default"
+}
@@ -0,0 +1,38 @@
+package nbfmt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVariables(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{`hello`, []string{}},
+		{`{{ user.Address.City }} {{ user.Name }} {{ user.Name }}`, []string{"user.Address.City", "user.Name"}},
+		{`{{ user }} {{ user?.Nick }} {{ x + y * 2 }} {{ !ok }}`, []string{"ok", "user", "user.Nick", "x", "y"}},
+		{`{{ for i, item in order.Items }}{{ i }}{{ item.Price }}{{ item.Tax() }}{{ loop.index }}{{ endfor }}`, []string{"order.Items", "order.Items[].Price", "order.Items[].Tax"}},
+		{`{{ for g in groups }}{{ for u in g.Users if u.Active }}{{ u.Name }}{{ endfor }}{{ endfor }}`, []string{"groups", "groups[].Users", "groups[].Users[].Active", "groups[].Users[].Name"}},
+		{`{{ for k, v in prices }}{{ k }}={{ v }}{{ else }}{{ empty }}{{ endfor }}{{ v }}`, []string{"empty", "prices", "v"}},
+		{`{{ for v in range(n) }}{{ v.x }}{{ endfor }}{{ for v in sort(items, "Price") }}{{ v.Name }}{{ endfor }}`, []string{"items", "n"}},
+		{`{{ items[i].Name }} {{ m["k"] }} {{ list[lo:hi] }} {{ rows[0][1] }}`, []string{"hi", "i", "items[].Name", "list", "lo", "m", "rows"}},
+		{`{{ [a, 1] }} {{ {"k": b} }} {{ format(c, date(d, "%Y")) }} {{ obj.method(e).Field }}`, []string{"a", "b", "c", "d", "e", "format", "obj.method"}},
+		{`{{ if defined(user.Nick) }}{{ default(user.Nick, user.Name) }}{{ elseif x in xs }}x{{ else }}{{ y }}{{ endif }}`, []string{"user.Name", "user.Nick", "x", "xs", "y"}},
+		{`{{ switch type(v) }}{{ case int }}{{ a }}{{ default }}{{ b }}{{ endswitch }}`, []string{"a", "b", "v"}},
+		{`{{ switch n }}{{ case > limit }}{{ a }}{{ case 1, m }}m{{ endswitch }}`, []string{"a", "limit", "m", "n"}},
+		{`{{ t "cart.items" cart.Count }} {{ t key }}`, []string{"cart.Count", "key"}},
+		{`{{ range }} {{ for range in ranges }}{{ range(1) }}{{ endfor }}`, []string{"range", "ranges"}},
+	}
+	for _, test := range tests {
+		temp, err := Parse(test.src)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got := temp.Variables(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}